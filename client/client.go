@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"exec/cmd"
+	"github.com/cenkalti/backoff/v4"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultMaxRetries = 5
+
+// Client talks to the v1 api. It's safe for concurrent use
+type Client struct {
+	baseUrl     string
+	httpClient  *http.Client
+	apiKey      string
+	bearerToken string
+	maxRetries  int
+}
+
+type Option func(*Client)
+
+func WithApiKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.bearerToken = token }
+}
+
+func WithHttpClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithMaxRetries sets how many times a failed request is repeated, 0 disables retries
+func WithMaxRetries(maxRetries int) Option {
+	return func(c *Client) { c.maxRetries = maxRetries }
+}
+
+// New creates a client for the api listening on baseUrl, e.g. "http://localhost:8000"
+func New(baseUrl string, options ...Option) *Client {
+	c := &Client{
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// do retries network errors, 5xx and 429 for idempotent requests, but only 429 for the rest,
+// because a 5xx may come after the task was already enqueued.
+// The caller has to close the body of the returned response
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body []byte) (*http.Response, error) {
+	idempotent := method == http.MethodGet || method == http.MethodDelete
+	back := backoff.NewExponentialBackOff()
+	back.MaxElapsedTime = 0
+	for attempt := 0; ; attempt++ {
+		resp, err := c.doOnce(ctx, method, path, contentType, body)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		delay := back.NextBackOff()
+		var apiErr *ApiError
+		if errors.As(err, &apiErr) {
+			if !apiErr.retryable() || !idempotent && apiErr.StatusCode != http.StatusTooManyRequests {
+				return nil, err
+			}
+			if apiErr.RetryAfter > delay {
+				delay = apiErr.RetryAfter
+			}
+		} else if !idempotent {
+			return nil, err
+		}
+		if attempt >= c.maxRetries {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, path string, contentType string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-Api-Key", c.apiKey)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readApiError(resp)
+}
+
+func readApiError(resp *http.Response) error {
+	apiErr := &ApiError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var body cmd.ApiError
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil && body.Error != "" {
+		apiErr.Message = body.Error
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// doJson sends request (if not nil) as json and decodes the response into response
+func (c *Client) doJson(ctx context.Context, method string, path string, request any, response any) error {
+	var body []byte
+	contentType := ""
+	if request != nil {
+		var err error
+		body, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	resp, err := c.do(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeJson(resp.Body, response)
+}
+
+func decodeJson(body io.Reader, response any) error {
+	return json.NewDecoder(body).Decode(response)
+}
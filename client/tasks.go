@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"exec/cmd"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+)
+
+// Statuses of a finished task, see Finished
+const (
+	StatusFinished  = "finished"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// Finished reports whether the task won't change its status anymore
+func Finished(task *cmd.ApiTask) bool {
+	return task.Status == StatusFinished || task.Status == StatusCancelled || task.Status == StatusExpired
+}
+
+// Submit enqueues a generic task running any of the tools allowed by the api
+func (c *Client) Submit(ctx context.Context, request *cmd.ApiTaskRequest) (*cmd.ApiTask, error) {
+	var task cmd.ApiTask
+	if err := c.doJson(ctx, http.MethodPost, "/v1/tasks", request, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Compile uploads the source and enqueues its compilation, notificationUrl may be empty
+func (c *Client) Compile(ctx context.Context, source io.Reader, filename string, notificationUrl string) (*cmd.ApiSubmission, error) {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, source); err != nil {
+		return nil, err
+	}
+	if notificationUrl != "" {
+		if err = form.WriteField("notification-url", notificationUrl); err != nil {
+			return nil, err
+		}
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/v1/submissions", form.FormDataContentType(), body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var submission cmd.ApiSubmission
+	if err = decodeJson(resp.Body, &submission); err != nil {
+		return nil, err
+	}
+	return &submission, nil
+}
+
+// Run enqueues a run of the binary produced by a finished submission, see also RunWithInput
+func (c *Client) Run(ctx context.Context, binaryId string, notificationUrl string) (*cmd.ApiRun, error) {
+	return c.run(ctx, &cmd.ApiRunRequest{BinaryId: binaryId, NotificationUrl: notificationUrl})
+}
+
+// RunWithInput is like Run, but feeds stdin to the binary
+func (c *Client) RunWithInput(ctx context.Context, binaryId string, stdin io.Reader, notificationUrl string) (*cmd.ApiRun, error) {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, err
+	}
+	return c.run(ctx, &cmd.ApiRunRequest{BinaryId: binaryId, Stdin: data, NotificationUrl: notificationUrl})
+}
+
+func (c *Client) run(ctx context.Context, request *cmd.ApiRunRequest) (*cmd.ApiRun, error) {
+	var run cmd.ApiRun
+	if err := c.doJson(ctx, http.MethodPost, "/v1/runs", request, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Status returns the current state of any task, submissions and runs included
+func (c *Client) Status(ctx context.Context, id string) (*cmd.ApiTask, error) {
+	var task cmd.ApiTask
+	if err := c.doJson(ctx, http.MethodGet, "/v1/tasks/"+url.PathEscape(id), nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// WaitForResult long-polls the api until the task is Finished, or ctx is done
+func (c *Client) WaitForResult(ctx context.Context, id string) (*cmd.ApiTask, error) {
+	for {
+		var task cmd.ApiTask
+		err := c.doJson(ctx, http.MethodGet, "/v1/tasks/"+url.PathEscape(id)+"?wait=1m", nil, &task)
+		if err != nil {
+			return nil, err
+		}
+		if Finished(&task) {
+			return &task, nil
+		}
+	}
+}
+
+// UploadArtifact stores content for use as an input of tasks, an empty contentType lets the server sniff it.
+// The class decides the retention, empty means cmd.SourceArtifact
+func (c *Client) UploadArtifact(ctx context.Context, content io.Reader, filename string, contentType string, class cmd.ArtifactClass) (*cmd.ApiArtifact, error) {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	if class != "" {
+		if err := form.WriteField("class", string(class)); err != nil {
+			return nil, err
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filename}))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, content); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/v1/artifacts", form.FormDataContentType(), body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var artifact cmd.ApiArtifact
+	if err = decodeJson(resp.Body, &artifact); err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// ArtifactMeta returns the metadata of the artifact
+func (c *Client) ArtifactMeta(ctx context.Context, id string) (*cmd.ApiArtifact, error) {
+	var artifact cmd.ApiArtifact
+	if err := c.doJson(ctx, http.MethodGet, "/v1/artifacts/"+url.PathEscape(id)+"/meta", nil, &artifact); err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// DownloadArtifact writes the content of the artifact to w
+func (c *Client) DownloadArtifact(ctx context.Context, id string, w io.Writer) error {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/artifacts/"+url.PathEscape(id), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Cancel stops the task, fails with ErrConflict if it's already finished
+func (c *Client) Cancel(ctx context.Context, id string) (*cmd.ApiTask, error) {
+	var task cmd.ApiTask
+	if err := c.doJson(ctx, http.MethodDelete, "/v1/tasks/"+url.PathEscape(id), nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
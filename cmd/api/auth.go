@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const apiKeyHeader = "X-Api-Key"
+
+var (
+	errUnauthenticated = errors.New("authentication required")
+	errBadCredentials  = errors.New("invalid credentials")
+	errNotOwner        = errors.New("owned by another principal")
+)
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// principalFrom returns the anonymous principal "" for unauthenticated contexts
+func principalFrom(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+type authenticator struct {
+	enabled    bool
+	apiKeys    map[string]string                       // HashApiKey(key) -> principal
+	apiKeysKvb common.KeyValueBucket[cmd.ApiKeyRecord] // Optional
+	jwt        *jwtVerifier                            // Optional
+}
+
+func newAuthenticator(config *cmd.AuthConfig, apiKeysKvb common.KeyValueBucket[cmd.ApiKeyRecord]) (*authenticator, error) {
+	if err := config.Check(); err != nil {
+		return nil, err
+	}
+	a := &authenticator{
+		enabled:    config.Enabled(),
+		apiKeys:    make(map[string]string),
+		apiKeysKvb: apiKeysKvb,
+	}
+	for _, key := range config.ApiKeys {
+		a.apiKeys[strings.ToLower(key.KeySha256)] = key.Principal
+	}
+	if config.JwtHmacSecret != "" || config.JwtPublicKeyFile != "" {
+		a.jwt = &jwtVerifier{
+			issuer:   config.JwtIssuer,
+			audience: config.JwtAudience,
+		}
+		if config.JwtHmacSecret != "" {
+			a.jwt.hmacSecret = []byte(config.JwtHmacSecret)
+		}
+		if config.JwtPublicKeyFile != "" {
+			key, err := loadRsaPublicKey(config.JwtPublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			a.jwt.publicKey = key
+		}
+	}
+	return a, nil
+}
+
+func (a *authenticator) lookupApiKey(ctx context.Context, key string) (string, error) {
+	hash := cmd.HashApiKey(key)
+	if principal, ok := a.apiKeys[hash]; ok {
+		return principal, nil
+	}
+	if a.apiKeysKvb == nil {
+		return "", errBadCredentials
+	}
+	entry, err := a.apiKeysKvb.Get(hash, ctx)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return "", errBadCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+	if entry.Value().Disabled {
+		return "", fmt.Errorf("%w: api key is disabled", errBadCredentials)
+	}
+	return entry.Value().Principal, nil
+}
+
+// authenticate returns errors wrapping errUnauthenticated or errBadCredentials for client side problems
+func (a *authenticator) authenticate(req *http.Request) (string, error) {
+	if !a.enabled {
+		return "", nil
+	}
+	if key := req.Header.Get(apiKeyHeader); key != "" {
+		return a.lookupApiKey(req.Context(), key)
+	}
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && a.jwt != nil {
+		principal, err := a.jwt.verify(token, time.Now())
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errBadCredentials, err)
+		}
+		return principal, nil
+	}
+	return "", errUnauthenticated
+}
+
+// checkOwnership passes everything when authentication is disabled
+func (a *authenticator) checkOwnership(ctx context.Context, owner string) error {
+	if !a.enabled || principalFrom(ctx) == owner {
+		return nil
+	}
+	return errNotOwner
+}
+
+// isNotFound Objects of other principals are reported as missing, so that their ids can't be probed
+func isNotFound(err error) bool {
+	return errors.Is(err, nats.ErrKeyNotFound) ||
+		errors.Is(err, common.ErrObjectNotFound) ||
+		errors.Is(err, errArtifactNotFound) ||
+		errors.Is(err, errNotOwner)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"net/http"
+)
+
+var errAlreadyFinished = errors.New("task is already finished")
+
+// cancel is picked up by the worker, which kills the tool if it's already running
+func (c *connection) cancel(ctx context.Context, id string) (*cmd.RunResult, error) {
+	entry, err := c.resultKvb.Get(id, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.auth.checkOwnership(ctx, entry.Value().Owner); err != nil {
+		return nil, err
+	}
+	result, _, err := c.resultKvb.CAS(
+		id,
+		func(result *cmd.RunResult) (bool, error) {
+			return result.Status.IsFinal(), nil
+		},
+		func(result *cmd.RunResult) error {
+			result.Status = cmd.Cancelled
+			return nil
+		},
+		ctx,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Status != cmd.Cancelled {
+		return nil, errAlreadyFinished
+	}
+	return result, nil
+}
+
+func (c *connection) handleCancelV1(resp http.ResponseWriter, req *http.Request) {
+	id := requestId(req)
+	result, err := c.cancel(req.Context(), id)
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, "task not found")
+		return
+	}
+	if errors.Is(err, errAlreadyFinished) {
+		c.returnErrorStr(resp, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to cancel: "+err.Error())
+		return
+	}
+	c.returnJson(resp, http.StatusOK, &cmd.ApiTask{
+		Id:     id,
+		Status: result.Status.ToString(),
+	})
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"exec/common"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 func CreateErrResponse(errMsg string) []byte {
@@ -13,3 +18,31 @@ func CreateErrResponse(errMsg string) []byte {
 	common.HandlePanic(e)
 	return data
 }
+
+var errBadNotificationUrl = errors.New("notification-url must be an absolute http(s) url")
+
+// parseNotificationUrl accepts empty url as "no notifications". Private hosts are refused by the notifier
+// when it connects, as only then their addresses are known
+func parseNotificationUrl(rawUrl string) (string, error) {
+	if rawUrl == "" {
+		return "", nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errBadNotificationUrl
+	}
+	return u.String(), nil
+}
+
+// requestId takes the id from the path of v1 routes or from the query of the legacy ones
+func requestId(req *http.Request) string {
+	if id, ok := mux.Vars(req)["id"]; ok {
+		return id
+	}
+	return req.URL.Query().Get("id")
+}
+
+// disableWriteDeadline exempts long-lived responses from the server write timeout
+func disableWriteDeadline(resp http.ResponseWriter) error {
+	return http.NewResponseController(resp).SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+)
+
+// finishFromCache creates an already finished task from the cached result of an identical compilation.
+// Returns a nil result on a miss, including when the cached artifacts were garbage collected
+func (c *connection) finishFromCache(ctx context.Context, task *cmd.TaskMsg) (string, *cmd.ToolResult, error) {
+	entry, err := c.compileCache.Get(task.CacheKey, ctx)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return "", nil, nil
+	}
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		return "", nil, nil
+	}
+	toolResultId := entry.Value().ToolResultId
+	toolResult, err := common.GetTypedObject[cmd.ToolResult](c.osb, toolResultId, c.toolResults, ctx)
+	if err != nil {
+		if !errors.Is(err, common.ErrObjectNotFound) {
+			common.HandleErrLog(err, c.logger)
+		}
+		return "", nil, nil
+	}
+	for _, name := range toolResult.OutputFiles {
+		if name == "" {
+			continue
+		}
+		if _, err = c.osb.GetInfo(name, ctx); err != nil {
+			if !errors.Is(err, common.ErrObjectNotFound) {
+				common.HandleErrLog(err, c.logger)
+			}
+			return "", nil, nil
+		}
+	}
+
+	id, reserved := newTaskId(ctx)
+	result := &cmd.RunResult{
+		Status:       cmd.Finished,
+		ToolResultId: toolResultId,
+		Owner:        principalFrom(ctx),
+	}
+	_, err = c.resultKvb.Create(id, result, ctx)
+	if errors.Is(err, nats.ErrKeyExists) && reserved {
+		return id, toolResult, nil // Repeated idempotent request, already notified
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	go c.notifier.Notify(task.NotificationUrl, id, result, c.logger)
+	return id, toolResult, nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"exec/cmd"
 	"exec/common"
+	nats2 "exec/nats"
 	"github.com/nats-io/nats.go"
 	"log"
 	"net/http"
@@ -11,9 +13,23 @@ import (
 type connection struct {
 	publisher    common.Publisher[cmd.TaskMsg]
 	resultKvb    common.KeyValueBucket[cmd.RunResult]
-	osb          nats.ObjectStore
-	tasksSubject string
-	logger       *log.Logger
+	osb          common.ObjectStore
+	toolResults  common.Serializer[cmd.ToolResult]
+	contentStore *nats2.ContentStore
+	notifier     *cmd.Notifier
+
+	compileCache     common.KeyValueBucket[cmd.CompileCacheEntry] // Nil if disabled
+	toolchainVersion string
+	tasksSubject     string
+	logger           *log.Logger
+	auth             *authenticator
+	limiter          *limiter
+	idempotency      *idempotency
+	allowedTools     map[string]bool
+	toolEnvironment  map[string]map[string]bool // Environment variables accepted by tools
+
+	outputListener  common.Listener[cmd.OutputChunk]
+	streamingConfig cmd.OutputStreamingConfig
 }
 
 func (c *connection) returnErrorStr(
@@ -28,3 +44,95 @@ func (c *connection) returnErrorStr(
 	_, err := resp.Write(CreateErrResponse(errMsg))
 	common.HandleErrLog(err, c.logger)
 }
+
+func (c *connection) returnJson(resp http.ResponseWriter, status int, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "error: "+err.Error())
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	_, err = resp.Write(data)
+	common.HandleErrLog(err, c.logger)
+}
+
+func newConnection(nc *nats.Conn, js nats.JetStreamContext, apiConfig *cmd.ApiConfig, logger *log.Logger) (*connection, error) {
+	serializers, err := cmd.NewSerializers(apiConfig.SerializationFormat)
+	if err != nil {
+		return nil, err
+	}
+	kvb, err := js.KeyValue(apiConfig.KeyValueBucketConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy := apiConfig.RetryConfig.Policy(logger)
+
+	osb, err := cmd.OpenObjectStore(js, &apiConfig.ObjectStoreConfig, &apiConfig.ObjectStoreBucketConfig, retryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKeysKvb common.KeyValueBucket[cmd.ApiKeyRecord]
+	if bucket := apiConfig.AuthConfig.ApiKeysBucketConfig.Name; bucket != "" {
+		kv, err := js.KeyValue(bucket)
+		if err != nil {
+			return nil, err
+		}
+		apiKeysKvb = nats2.NewKeyValueTypedWrapper[cmd.ApiKeyRecord](kv, &common.JsonSerializer[cmd.ApiKeyRecord]{}, retryPolicy)
+	}
+	auth, err := newAuthenticator(&apiConfig.AuthConfig, apiKeysKvb)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.enabled {
+		logger.Printf("Warning: authentication is disabled, every request is served to the anonymous principal")
+	}
+
+	conn := &connection{
+		publisher:    nats2.NewPublisherWrapper[cmd.TaskMsg](js, serializers.Task, retryPolicy),
+		resultKvb:    nats2.NewKeyValueTypedWrapper[cmd.RunResult](kvb, serializers.Result, retryPolicy, common.WithLogger(logger)),
+		osb:          osb,
+		toolResults:  serializers.ToolResult,
+		contentStore: nats2.NewContentStore(osb, kvb, retryPolicy),
+		notifier:     cmd.NewNotifier(&apiConfig.NotificationConfig),
+		tasksSubject: apiConfig.TasksSubject,
+		logger:       logger,
+		auth:         auth,
+		limiter: &limiter{
+			config:  apiConfig.RateLimitConfig,
+			buckets: nats2.NewKeyValueTypedWrapper[tokenBucket](kvb, &common.JsonSerializer[tokenBucket]{}, retryPolicy),
+			quotas:  nats2.NewKeyValueTypedWrapper[cmd.QuotaUsage](kvb, &common.JsonSerializer[cmd.QuotaUsage]{}, retryPolicy),
+		},
+		idempotency: &idempotency{
+			records: nats2.NewKeyValueTypedWrapper[cmd.IdempotencyRecord](kvb, &common.JsonSerializer[cmd.IdempotencyRecord]{}, retryPolicy),
+			ttl:     apiConfig.IdempotencyKeyTtl.Duration,
+			logger:  logger,
+		},
+
+		outputListener:  nats2.NewListenerWrapper[cmd.OutputChunk](nc, &common.JsonSerializer[cmd.OutputChunk]{}),
+		streamingConfig: apiConfig.OutputStreamingConfig,
+	}
+
+	if cacheConfig := apiConfig.CompileCacheConfig; cacheConfig.Enabled() {
+		cacheKv, err := js.KeyValue(cacheConfig.KeyValueBucketConfig.Name)
+		if err != nil {
+			return nil, err
+		}
+		conn.compileCache = nats2.NewKeyValueTypedWrapper[cmd.CompileCacheEntry](cacheKv, &common.JsonSerializer[cmd.CompileCacheEntry]{}, retryPolicy)
+		conn.toolchainVersion = cacheConfig.ToolchainVersion
+	}
+
+	conn.allowedTools = make(map[string]bool)
+	for _, tool := range apiConfig.AllowedTools {
+		conn.allowedTools[tool] = true
+	}
+	conn.toolEnvironment = make(map[string]map[string]bool)
+	for tool, names := range apiConfig.ToolEnvironment {
+		conn.toolEnvironment[tool] = make(map[string]bool)
+		for _, name := range names {
+			conn.toolEnvironment[tool][name] = true
+		}
+	}
+	return conn, nil
+}
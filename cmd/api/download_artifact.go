@@ -1,43 +1,55 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
+	"exec/cmd"
 	"exec/common"
-	"github.com/nats-io/nats.go"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// gzipMinSize below which compressing isn't worth it
+const gzipMinSize = 1024
+
 var errArtifactNotFound = errors.New("artifact not found")
 
-func (c *connection) downloadArtifact(id string, file io.Writer, onSuccess func(size uint64)) error {
-	or, err := c.osb.Get(id)
+// artifactInfo returns the info of the artifact and of the object holding its content, which differ for uploads
+func (c *connection) artifactInfo(ctx context.Context, id string) (*common.ObjectInfo, *common.ObjectInfo, error) {
+	oi, err := c.osb.GetInfo(id, ctx)
+	if errors.Is(err, common.ErrObjectNotFound) {
+		return nil, nil, errArtifactNotFound
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if err = c.auth.checkOwnership(ctx, cmd.ObjectOwner(oi)); err != nil {
+		return nil, nil, err
+	}
+	name := cmd.ObjectContent(oi)
+	if name == oi.Name {
+		return oi, oi, nil
 	}
-	oi, err := or.Info()
-	if errors.Is(err, nats.ErrObjectNotFound) {
-		return errArtifactNotFound
+	content, err := c.osb.GetInfo(name, ctx)
+	if errors.Is(err, common.ErrObjectNotFound) {
+		return nil, nil, errArtifactNotFound
 	}
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	onSuccess(oi.Size)
-	_, err = io.Copy(file, or)
-	return err
+	return oi, content, nil
 }
 
+// handleDownloadArtifact supports conditional and range requests, so the head or tail of large outputs
+// can be fetched alone. Whole artifacts are gzipped if the client accepts it
 func (c *connection) handleDownloadArtifact(resp http.ResponseWriter, req *http.Request) {
-	id := req.URL.Query().Get("id")
-
-	err := c.downloadArtifact(id, resp, func(size uint64) {
-		resp.WriteHeader(http.StatusOK)
-		resp.Header().Set("Content-Type", "application/octet-stream")
-		resp.Header().Set("Content-Length", strconv.FormatUint(size, 10))
-	})
-	if errors.Is(err, errArtifactNotFound) {
-		c.returnErrorStr(resp, http.StatusNotFound, err.Error())
+	oi, content, err := c.artifactInfo(req.Context(), requestId(req))
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, errArtifactNotFound.Error())
 		return
 	}
 	if err != nil {
@@ -45,4 +57,80 @@ func (c *connection) handleDownloadArtifact(resp http.ResponseWriter, req *http.
 		c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
 		return
 	}
+
+	artifact := artifactFromInfo(oi, content)
+	// Content types of uploads are chosen by clients, so artifacts are never rendered on the api origin
+	resp.Header().Set("Content-Type", artifact.ContentType)
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	disposition := "attachment"
+	if artifact.Filename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": artifact.Filename})
+	}
+	resp.Header().Set("Content-Disposition", disposition)
+	resp.Header().Set("Vary", "Accept-Encoding")
+
+	if req.Header.Get("Range") == "" && content.Size >= gzipMinSize && acceptsGzip(req) {
+		c.serveGzipped(resp, req, content)
+		return
+	}
+	if content.Digest != "" {
+		resp.Header().Set("ETag", strconv.Quote(content.Digest))
+	}
+	reader := common.NewObjectReadSeeker(c.osb, content, req.Context())
+	defer func() {
+		common.HandleErrLog(reader.Close(), c.logger)
+	}()
+	http.ServeContent(resp, req, "", content.ModTime, reader)
+}
+
+func (c *connection) serveGzipped(resp http.ResponseWriter, req *http.Request, oi *common.ObjectInfo) {
+	if oi.Digest != "" {
+		// The encoded representation differs, so must its tag
+		etag := strconv.Quote(oi.Digest + "-gzip")
+		resp.Header().Set("ETag", etag)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	object, err := c.osb.Stream(oi.Name, 0, req.Context())
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		common.HandleErrLog(object.Close(), c.logger)
+	}()
+
+	resp.Header().Set("Content-Encoding", "gzip")
+	resp.Header().Set("Last-Modified", oi.ModTime.UTC().Format(http.TimeFormat))
+	resp.WriteHeader(http.StatusOK)
+	gz := gzip.NewWriter(resp)
+	_, err = io.Copy(gz, object)
+	common.HandleErrLog(err, c.logger)
+	common.HandleErrLog(gz.Close(), c.logger)
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, coding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// etagMatches implements the weak comparison of If-None-Match
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
 }
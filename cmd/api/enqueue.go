@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+)
+
+// enqueue attributes the task to the principal of ctx, creates its status entry and publishes it.
+// The id is reserved by Idempotent for idempotent requests
+func (c *connection) enqueue(ctx context.Context, task *cmd.TaskMsg) (string, error) {
+	var reserved bool
+	task.KVId, reserved = newTaskId(ctx)
+	task.Owner = principalFrom(ctx)
+	_, err := c.resultKvb.Create(task.KVId, &cmd.RunResult{
+		Status: cmd.Enqueued,
+		Owner:  task.Owner,
+	}, ctx)
+	if errors.Is(err, nats.ErrKeyExists) && reserved {
+		// Repeated idempotent request, a worker only changes the status of published tasks
+		entry, err := c.resultKvb.Get(task.KVId, ctx)
+		if err != nil {
+			return "", err
+		}
+		if entry.Value().Published || entry.Value().Status != cmd.Enqueued {
+			return task.KVId, nil
+		}
+	} else if err != nil {
+		return "", err
+	}
+	// Retries after a lost ack would enqueue the task twice otherwise
+	err = c.publisher.PublishSync(c.tasksSubject, task, common.WithMsgId(task.KVId))
+	if err != nil {
+		return "", err
+	}
+	if reserved {
+		// Repeats may come long after the duplicate window of the stream, while the task is still queued.
+		// Only a crash right before this leaves it to the msg id
+		_, _, err = c.resultKvb.CAS(
+			task.KVId,
+			func(current *cmd.RunResult) (bool, error) {
+				return current.Published, nil
+			},
+			func(current *cmd.RunResult) error {
+				current.Published = true
+				return nil
+			},
+			ctx,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+	return task.KVId, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"exec/cmd"
+	"exec/common"
+	"net/http"
+	"time"
+)
+
+const eventsHeartbeatInterval = 15 * time.Second
+
+// handleEvents streams status transitions of the task as server-sent events
+// until the task is finished or the client goes away
+func (c *connection) handleEvents(resp http.ResponseWriter, req *http.Request) {
+	id := requestId(req)
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	results, err := c.watchRunResult(req.Context(), id)
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, "id not found")
+		return
+	}
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "error: "+err.Error())
+		return
+	}
+
+	common.HandleErrLog(disableWriteDeadline(resp), c.logger)
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-heartbeat.C:
+			_, err = resp.Write([]byte(": heartbeat\n\n"))
+		case result, ok := <-results:
+			if !ok {
+				return
+			}
+			data, e := json.Marshal(&cmd.StatusNotification{
+				Id:           id,
+				Status:       result.Status.ToString(),
+				ToolResultId: result.ToolResultId,
+			})
+			common.HandleErrLog(e, c.logger)
+			_, err = common.Fprintfln(resp, "event: status\ndata: %s\n", data)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
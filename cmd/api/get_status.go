@@ -1,25 +1,28 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"exec/cmd"
 	"exec/common"
-	nats2 "exec/nats"
-	"github.com/nats-io/nats.go"
 	"net/http"
+	"time"
 )
 
-func (c *connection) getStatus(id string) (cmd.RunStatus, *cmd.ToolResult, error) {
-	status, err := c.resultKvb.Get(id)
+func (c *connection) getStatus(ctx context.Context, id string, wait time.Duration) (cmd.RunStatus, *cmd.ToolResult, error) {
+	status, err := c.awaitRunResult(ctx, id, wait)
 	if err != nil {
 		return cmd.Enqueued, nil, err
 	}
-	rStatus := status.Value().Status
+	rStatus := status.Status
 	if rStatus != cmd.Finished {
 		return rStatus, nil, nil
 	}
-	toolResult, err := nats2.TypedRobustGetObject[cmd.ToolResult](c.osb, status.Value().ToolResultId, &common.JsonSerializer[cmd.ToolResult]{})
+	toolResult, err := common.GetTypedObject[cmd.ToolResult](c.osb, status.ToolResultId, c.toolResults, ctx)
+	if errors.Is(err, common.ErrObjectNotFound) {
+		// The garbage collector marks the task expired before deleting the result, so it has just happened
+		return cmd.Expired, nil, nil
+	}
 	if err != nil {
 		return rStatus, nil, err
 	}
@@ -30,12 +33,20 @@ func (c *connection) genericHandleGetStatus(
 	resp http.ResponseWriter,
 	req *http.Request,
 	notFoundMsg string,
-	expectedOutputFiles int,
-	getStatusImpl func(cmd.RunStatus, *cmd.ToolResult) []byte,
+	expectedOutputFiles int, // Negative accepts any number
+	getStatusImpl func(string, cmd.RunStatus, *cmd.ToolResult) any,
 ) {
-	id := req.URL.Query().Get("id")
-	status, result, err := c.getStatus(id)
-	if errors.Is(err, nats.ErrKeyNotFound) || (result != nil && len(result.OutputFiles) != expectedOutputFiles) {
+	id := requestId(req)
+	wait, err := parseWait(req.URL.Query().Get("wait"))
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, "bad wait: "+err.Error())
+		return
+	}
+	if wait > 0 {
+		common.HandleErrLog(disableWriteDeadline(resp), c.logger)
+	}
+	status, result, err := c.getStatus(req.Context(), id, wait)
+	if isNotFound(err) || (result != nil && expectedOutputFiles >= 0 && len(result.OutputFiles) != expectedOutputFiles) {
 		c.returnErrorStr(resp, http.StatusNotFound, notFoundMsg)
 		return
 	}
@@ -43,14 +54,10 @@ func (c *connection) genericHandleGetStatus(
 		c.returnErrorStr(resp, http.StatusInternalServerError, "error: "+err.Error())
 		return
 	}
-	data := getStatusImpl(status, result)
-
-	resp.WriteHeader(http.StatusOK)
-	_, err = resp.Write(data)
-	common.HandleErrLog(err, c.logger)
+	c.returnJson(resp, http.StatusOK, getStatusImpl(id, status, result))
 }
 
-func (c *connection) handleGetCompilationStatusImpl(status cmd.RunStatus, result *cmd.ToolResult) []byte {
+func (c *connection) handleGetCompilationStatusImpl(_ string, status cmd.RunStatus, result *cmd.ToolResult) any {
 	type Result struct {
 		Status   string `json:"status"`
 		BinaryId string `json:"binary-id,omitempty"`
@@ -65,13 +72,10 @@ func (c *connection) handleGetCompilationStatusImpl(status cmd.RunStatus, result
 		res.ErrLogId = result.OutputFiles[1]
 		res.Stats = result.ToolOutput
 	}
-
-	data, err := json.Marshal(&res)
-	common.HandleErrLog(err, c.logger)
-	return data
+	return &res
 }
 
-func (c *connection) handleGetRunStatusImpl(status cmd.RunStatus, result *cmd.ToolResult) []byte {
+func (c *connection) handleGetRunStatusImpl(_ string, status cmd.RunStatus, result *cmd.ToolResult) any {
 	type Result struct {
 		Status     string `json:"status"`
 		OutputId   string `json:"stdout-id,omitempty"`
@@ -86,9 +90,7 @@ func (c *connection) handleGetRunStatusImpl(status cmd.RunStatus, result *cmd.To
 		res.ErrorLogId = result.OutputFiles[1]
 		res.Stats = result.ToolOutput
 	}
-	data, err := json.Marshal(&res)
-	common.HandleErrLog(err, c.logger)
-	return data
+	return &res
 }
 
 func (c *connection) handleGetCompilationStatus(resp http.ResponseWriter, req *http.Request) {
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func RequireKey(key string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
@@ -15,3 +19,47 @@ func RequireKey(key string, handler func(http.ResponseWriter, *http.Request)) fu
 		handler(resp, req)
 	}
 }
+
+// RequireAuth attaches the authenticated principal to the request context, see principalFrom
+func (a *authenticator) RequireAuth(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		principal, err := a.authenticate(req)
+		if errors.Is(err, errUnauthenticated) || errors.Is(err, errBadCredentials) {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			resp.WriteHeader(http.StatusUnauthorized)
+			_, _ = resp.Write(CreateErrResponse(err.Error()))
+			return
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(CreateErrResponse("authentication failed: " + err.Error()))
+			return
+		}
+		handler(resp, req.WithContext(withPrincipal(req.Context(), principal)))
+	}
+}
+
+// Limit rejects requests over the rate limit or daily quotas with 429, has to be wrapped by RequireAuth
+func (l *limiter) Limit(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+		retryAfter, err := l.take(req.Context(), clientKey(req), now)
+		msg := "rate limit exceeded"
+		if err == nil && retryAfter == 0 {
+			retryAfter, err = l.checkQuota(req.Context(), principalFrom(req.Context()), now)
+			msg = "daily quota exhausted"
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(CreateErrResponse("rate limiting failed: " + err.Error()))
+			return
+		}
+		if retryAfter > 0 {
+			resp.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+			resp.WriteHeader(http.StatusTooManyRequests)
+			_, _ = resp.Write(CreateErrResponse(msg))
+			return
+		}
+		handler(resp, req)
+	}
+}
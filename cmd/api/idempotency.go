@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodySize   = 2 << 20 // Above the limits of the idempotent endpoints, which check them on their own
+)
+
+var errIdempotencyKeyReused = errors.New("idempotency key was used for another request")
+
+type taskIdKey struct{}
+
+func withTaskId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIdKey{}, id)
+}
+
+// newTaskId returns the id reserved for an idempotent request, which may already be taken by a previous attempt,
+// otherwise a random one. reserved tells them apart
+func newTaskId(ctx context.Context) (id string, reserved bool) {
+	if id, ok := ctx.Value(taskIdKey{}).(string); ok {
+		return id, true
+	}
+	return common.GetRandomId(), false
+}
+
+type idempotency struct {
+	records common.KeyValueBucket[cmd.IdempotencyRecord]
+	ttl     time.Duration // Zero disables idempotency keys
+	logger  *log.Logger
+}
+
+// responseRecorder keeps a copy of the response, so it can be replayed
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// Idempotent replays the response of the first successful request with the same Idempotency-Key header.
+// Repeats of requests which didn't succeed yet run again with the task id of the first one, so its message
+// is deduplicated by JetStream if it was published already. Has to be wrapped by RequireAuth
+func (i *idempotency) Idempotent(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if i.ttl == 0 || key == "" {
+			handler(resp, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			resp.WriteHeader(http.StatusBadRequest)
+			_, _ = resp.Write(CreateErrResponse("idempotency key is too long"))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(resp, req.Body, maxIdempotentBodySize))
+		if err != nil {
+			resp.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = resp.Write(CreateErrResponse("failed to read the request body: " + err.Error()))
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		key = cmd.IdempotencyKey(principalFrom(req.Context()), key)
+		record, err := i.reserve(req.Context(), key, requestFingerprint(req, body), time.Now())
+		if errors.Is(err, errIdempotencyKeyReused) {
+			resp.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = resp.Write(CreateErrResponse(err.Error()))
+			return
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusInternalServerError)
+			_, _ = resp.Write(CreateErrResponse("idempotency check failed: " + err.Error()))
+			return
+		}
+		if record.Completed() {
+			if record.ContentType != "" {
+				resp.Header().Set("Content-Type", record.ContentType)
+			}
+			resp.Header().Set("Idempotent-Replayed", "true")
+			resp.WriteHeader(record.StatusCode)
+			_, err = resp.Write(record.Body)
+			common.HandleErrLog(err, i.logger)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: resp}
+		handler(recorder, req.WithContext(withTaskId(req.Context(), record.TaskId)))
+		if recorder.status < 200 || recorder.status >= 300 {
+			return
+		}
+		_, _, err = i.records.CAS(
+			key,
+			func(current *cmd.IdempotencyRecord) (bool, error) {
+				return current.Completed() || current.TaskId != record.TaskId, nil
+			},
+			func(current *cmd.IdempotencyRecord) error {
+				current.StatusCode = recorder.status
+				current.ContentType = recorder.Header().Get("Content-Type")
+				current.Body = recorder.body.Bytes()
+				return nil
+			},
+			context.Background(),
+		)
+		common.HandleErrLog(err, i.logger)
+	}
+}
+
+// requestFingerprint tells apart requests which may not share an idempotency key
+func requestFingerprint(req *http.Request, body []byte) string {
+	// Boundaries are random, so repeats of the same form differ only in them
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if boundary := params["boundary"]; err == nil && boundary != "" {
+		body = bytes.ReplaceAll(body, []byte(boundary), nil)
+	}
+	sum := sha256.Sum256(body)
+	return req.Method + " " + req.URL.RequestURI() + " sha256=" + hex.EncodeToString(sum[:])
+}
+
+// reserve returns the record of the key, creating it with a new task id unless there's one which isn't expired
+func (i *idempotency) reserve(ctx context.Context, key string, request string, now time.Time) (*cmd.IdempotencyRecord, error) {
+	for {
+		fresh := &cmd.IdempotencyRecord{
+			Request: request,
+			TaskId:  common.GetRandomId(),
+			Expires: now.Add(i.ttl),
+		}
+		entry, err := i.records.Get(key, ctx)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			_, err = i.records.Create(key, fresh, ctx)
+			if errors.Is(err, nats.ErrKeyExists) {
+				continue // Created by a concurrent repeat
+			}
+			if err != nil {
+				return nil, err
+			}
+			return fresh, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := entry.Value()
+		if record.Expires.Before(now) {
+			_, err = i.records.Update(key, fresh, entry.Revision(), ctx)
+			if errors.Is(err, common.ErrWrongRevNumber) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return fresh, nil
+		}
+		if record.Request != request {
+			return nil, errIdempotencyKeyReused
+		}
+		return record, nil
+	}
+}
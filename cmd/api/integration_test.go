@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"exec/cmd"
+	"exec/cmd/worker/executor"
+	"exec/common"
+	nats2 "exec/nats"
+	"exec/nats/natstest"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testApiKey  = "alicekey"
+	testAckWait = 2 * time.Second
+)
+
+// fakeTools mimic the real ones closely enough for the api: the compiler copies the source as the binary
+// and the binary is "run" by echoing its stdin
+var fakeTools = map[string]string{
+	"clang_compile": `cp "$1" "$2"; echo "compiled ok" > "$3"; echo '{"time":1}'`,
+	"run":           `cat "$2" > "$3"; echo "stderr" > "$4"; echo '{"time":1}'`,
+}
+
+type testEnv struct {
+	t            *testing.T
+	nc           *nats.Conn
+	js           nats.JetStreamContext
+	workerConfig *cmd.WorkerConfig
+	api          *httptest.Server
+}
+
+// newTestEnv runs nats and the api, configure may adjust the configs before they're used
+func newTestEnv(t *testing.T, configure ...func(*cmd.WorkerConfig, *cmd.ApiConfig)) *testEnv {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	s := natstest.RunJetStream(t)
+	nc, js := natstest.Connect(t, s)
+
+	toolsPath := t.TempDir()
+	for name, script := range fakeTools {
+		err := os.WriteFile(filepath.Join(toolsPath, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	workerConfig := &cmd.WorkerConfig{
+		WorkerThreads: 2,
+		PathToTools:   toolsPath,
+		ConsumerConfig: cmd.ConsumerConfig{
+			StreamName:  "tasks",
+			Name:        "workers",
+			AckWaitTime: cmd.Duration{Duration: testAckWait},
+			Replicas:    1,
+		},
+		ObjectStoreBucketConfig: cmd.ObjectStoreBucketConfig{Name: "artifacts", Replicas: 1},
+		KeyValueBucketConfig:    cmd.KeyValueBucketConfig{Name: "db", Replicas: 1},
+		OutputStreamingConfig:   cmd.OutputStreamingConfig{SubjectPrefix: "output", ChunkSize: 4096, MaxStreamedBytes: 1 << 20},
+	}
+	apiConfig := &cmd.ApiConfig{
+		TasksSubject:            "tasks",
+		AllowedTools:            []string{"clang_compile", "run"},
+		IdempotencyKeyTtl:       cmd.Duration{Duration: time.Hour},
+		ObjectStoreBucketConfig: workerConfig.ObjectStoreBucketConfig,
+		KeyValueBucketConfig:    workerConfig.KeyValueBucketConfig,
+		OutputStreamingConfig:   workerConfig.OutputStreamingConfig,
+		AuthConfig: cmd.AuthConfig{
+			ApiKeys: []cmd.ApiKeyConfig{{Principal: "alice", KeySha256: cmd.HashApiKey(testApiKey)}},
+		},
+	}
+	for _, c := range configure {
+		c(workerConfig, apiConfig)
+	}
+	if err := cmd.SetupJetStream(js, workerConfig, apiConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := newConnection(nc, js, apiConfig, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := httptest.NewServer(newRouter(conn))
+	t.Cleanup(api.Close)
+	return &testEnv{t: t, nc: nc, js: js, workerConfig: workerConfig, api: api}
+}
+
+// startWorkers runs the configured number of workers until the test ends
+func (e *testEnv) startWorkers() {
+	t, config := e.t, e.workerConfig
+	kv, err := e.js.KeyValue(config.KeyValueBucketConfig.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryPolicy := config.RetryConfig.Policy(nil)
+	osb, err := cmd.OpenObjectStore(e.js, &config.ObjectStoreConfig, &config.ObjectStoreBucketConfig, retryPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumerConfig := config.ConsumerConfig
+	sub, err := e.js.PullSubscribe("", consumerConfig.Name, nats.Bind(consumerConfig.StreamName, consumerConfig.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializers, err := cmd.NewSerializers(config.SerializationFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typedSub := nats2.NewSubscriptionWrapper[cmd.TaskMsg](sub, serializers.Task, retryPolicy)
+	kvb := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, serializers.Result, retryPolicy)
+	quotaKvb := nats2.NewKeyValueTypedWrapper[cmd.QuotaUsage](kv, &common.JsonSerializer[cmd.QuotaUsage]{}, retryPolicy)
+	broadcaster := nats2.NewBroadcasterWrapper[cmd.OutputChunk](e.nc, &common.JsonSerializer[cmd.OutputChunk]{})
+	notifier := cmd.NewNotifier(&config.NotificationConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < config.WorkerThreads; i++ {
+		logger := log.New(io.Discard, fmt.Sprintf("Worker #%d: ", i), 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			executor.Run(ctx, typedSub, osb, kvb, logger, config.PathToTools, serializers.ToolResult, notifier, broadcaster, &config.OutputStreamingConfig, quotaKvb, nil)
+		}()
+	}
+	// Registered after the server cleanups, so workers stop before nats does
+	e.t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func (e *testEnv) request(method string, path string, contentType string, body io.Reader) *http.Request {
+	e.t.Helper()
+	req, err := http.NewRequest(method, e.api.URL+path, body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", testApiKey)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+// send checks the status and decodes the response into result, raw if it's a *[]byte
+func (e *testEnv) send(req *http.Request, status int, result any) http.Header {
+	e.t.Helper()
+	resp, err := e.api.Client().Do(req)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		e.t.Fatalf("%s %s: expected status %d, got %d: %s", req.Method, req.URL.Path, status, resp.StatusCode, data)
+	}
+	if result == nil {
+		return resp.Header
+	}
+	if raw, ok := result.(*[]byte); ok {
+		*raw = data
+		return resp.Header
+	}
+	if err = json.Unmarshal(data, result); err != nil {
+		e.t.Fatalf("%s %s: %v: %s", req.Method, req.URL.Path, err, data)
+	}
+	return resp.Header
+}
+
+func (e *testEnv) do(method string, path string, contentType string, body io.Reader, status int, result any) {
+	e.t.Helper()
+	e.send(e.request(method, path, contentType, body), status, result)
+}
+
+// submitRequest is a fresh multipart form every time, like clients retrying with curl send
+func (e *testEnv) submitRequest(source string) *http.Request {
+	e.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "main.cpp")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if _, err = part.Write([]byte(source)); err != nil {
+		e.t.Fatal(err)
+	}
+	if err = form.Close(); err != nil {
+		e.t.Fatal(err)
+	}
+	return e.request(http.MethodPost, "/v1/submissions", form.FormDataContentType(), &body)
+}
+
+func (e *testEnv) submit(source string) *cmd.ApiSubmission {
+	e.t.Helper()
+	var submission cmd.ApiSubmission
+	e.send(e.submitRequest(source), http.StatusCreated, &submission)
+	return &submission
+}
+
+// awaitFinal long-polls path until the task is in a final state
+func (e *testEnv) awaitFinal(path string, result any, status func() string) {
+	e.t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		e.do(http.MethodGet, path+"?wait=5s", "", nil, http.StatusOK, result)
+		switch status() {
+		case cmd.Finished.ToString(), cmd.Cancelled.ToString(), cmd.Expired.ToString():
+			return
+		}
+	}
+	e.t.Fatalf("%s isn't finished, last status %s", path, status())
+}
+
+func (e *testEnv) download(id string) string {
+	e.t.Helper()
+	var content []byte
+	e.do(http.MethodGet, "/v1/artifacts/"+id, "", nil, http.StatusOK, &content)
+	return string(content)
+}
+
+func (e *testEnv) awaitSubmission(id string) *cmd.ApiSubmission {
+	e.t.Helper()
+	var submission cmd.ApiSubmission
+	e.awaitFinal("/v1/submissions/"+id, &submission, func() string { return submission.Status })
+	if submission.Status != cmd.Finished.ToString() {
+		e.t.Fatalf("submission %s is %s", id, submission.Status)
+	}
+	return &submission
+}
+
+func TestSubmitCompileRunDownload(t *testing.T) {
+	env := newTestEnv(t)
+	env.startWorkers()
+	env.compileRunDownload()
+}
+
+// TestMixedSerializationFormats runs the api and the workers with different formats, like during a rolling upgrade
+func TestMixedSerializationFormats(t *testing.T) {
+	for _, formats := range [][2]string{{"msgpack", "json"}, {"json", "cbor"}, {"protobuf", "msgpack"}} {
+		formats := formats
+		t.Run(formats[0]+"-"+formats[1], func(t *testing.T) {
+			env := newTestEnv(t, func(workerConfig *cmd.WorkerConfig, apiConfig *cmd.ApiConfig) {
+				workerConfig.SerializationFormat = formats[0]
+				apiConfig.SerializationFormat = formats[1]
+			})
+			env.startWorkers()
+			env.compileRunDownload()
+		})
+	}
+}
+
+// compileRunDownload compiles a program, runs it and checks all the artifacts
+func (e *testEnv) compileRunDownload() {
+	t := e.t
+	t.Helper()
+	const source = "int main() { return 0; }\n"
+	submission := e.awaitSubmission(e.submit(source).Id)
+	if submission.BinaryId == "" || submission.LogId == "" {
+		t.Fatalf("missing artifacts: %+v", submission)
+	}
+	if binary := e.download(submission.BinaryId); binary != source {
+		t.Fatalf("unexpected binary %q", binary)
+	}
+	if compileLog := e.download(submission.LogId); compileLog != "compiled ok\n" {
+		t.Fatalf("unexpected compile log %q", compileLog)
+	}
+
+	stdin := "hello\xff\x00"
+	body, err := json.Marshal(&cmd.ApiRunRequest{BinaryId: submission.BinaryId, Stdin: []byte(stdin)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run cmd.ApiRun
+	e.do(http.MethodPost, "/v1/runs", "application/json", bytes.NewReader(body), http.StatusCreated, &run)
+	e.awaitFinal("/v1/runs/"+run.Id, &run, func() string { return run.Status })
+	if run.Status != cmd.Finished.ToString() {
+		t.Fatalf("run %s is %s", run.Id, run.Status)
+	}
+	if stdout := e.download(run.StdoutId); stdout != stdin {
+		t.Fatalf("unexpected stdout %q", stdout)
+	}
+	if stderr := e.download(run.StderrId); stderr != "stderr\n" {
+		t.Fatalf("unexpected stderr %q", stderr)
+	}
+}
+
+// TestRedeliveryOnWorkerCrash takes the task like a worker which dies right after marking it processing,
+// the task has to be picked up again once the ack wait passes
+func TestRedeliveryOnWorkerCrash(t *testing.T) {
+	env := newTestEnv(t)
+	submission := env.submit("int main() {}\n")
+
+	consumerConfig := env.workerConfig.ConsumerConfig
+	sub, err := env.js.PullSubscribe("", consumerConfig.Name, nats.Bind(consumerConfig.StreamName, consumerConfig.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := sub.Fetch(1, nats.MaxWait(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task cmd.TaskMsg
+	if err = json.Unmarshal(msgs[0].Data, &task); err != nil {
+		t.Fatal(err)
+	}
+	if task.KVId != submission.Id {
+		t.Fatalf("fetched task %s instead of %s", task.KVId, submission.Id)
+	}
+	kv, err := env.js.KeyValue(env.workerConfig.KeyValueBucketConfig.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvb := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, &common.JsonSerializer[cmd.RunResult]{}, env.workerConfig.RetryConfig.Policy(nil))
+	_, _, err = kvb.CAS(
+		task.KVId,
+		func(*cmd.RunResult) (bool, error) { return false, nil },
+		func(result *cmd.RunResult) error {
+			result.Status = cmd.Processing
+			return nil
+		},
+		context.Background(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	common.HandleErrLog(sub.Unsubscribe(), log.Default()) // Crashed
+
+	crashed := time.Now()
+	env.startWorkers()
+	env.awaitSubmission(submission.Id)
+	if elapsed := time.Since(crashed); elapsed < testAckWait/2 {
+		t.Fatalf("redelivered after %v, before the ack wait", elapsed)
+	}
+
+	info, err := env.js.ConsumerInfo(consumerConfig.StreamName, consumerConfig.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumRedelivered != 0 || info.NumAckPending != 0 || info.NumPending != 0 {
+		t.Fatalf("task wasn't acked: %+v", info)
+	}
+}
+
+func (e *testEnv) queueLen() uint64 {
+	e.t.Helper()
+	info, err := e.js.StreamInfo(e.workerConfig.ConsumerConfig.StreamName)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return info.State.Msgs
+}
+
+func TestIdempotentSubmission(t *testing.T) {
+	env := newTestEnv(t)
+	const source = "int main() {}\n"
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		req := env.submitRequest(source)
+		req.Header.Set(idempotencyKeyHeader, "first")
+		var submission cmd.ApiSubmission
+		header := env.send(req, http.StatusCreated, &submission)
+		if replayed := header.Get("Idempotent-Replayed") == "true"; replayed != (i == 1) {
+			t.Fatalf("attempt #%d replayed: %v", i, replayed)
+		}
+		ids = append(ids, submission.Id)
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("repeated submission created task %s besides %s", ids[1], ids[0])
+	}
+	if n := env.queueLen(); n != 1 {
+		t.Fatalf("expected a single queued task, got %d", n)
+	}
+
+	req := env.request(http.MethodPost, "/v1/runs", "application/json", strings.NewReader(`{"binary-id":"x"}`))
+	req.Header.Set(idempotencyKeyHeader, "first")
+	env.send(req, http.StatusUnprocessableEntity, nil)
+	req = env.submitRequest("int main() { return 1; }\n")
+	req.Header.Set(idempotencyKeyHeader, "first")
+	env.send(req, http.StatusUnprocessableEntity, nil)
+	req = env.request(http.MethodPost, "/run?id=x", "", nil)
+	req.Header.Set(idempotencyKeyHeader, "legacy")
+	env.send(req, http.StatusNotFound, nil)
+	req = env.request(http.MethodPost, "/run?id=y", "", nil)
+	req.Header.Set(idempotencyKeyHeader, "legacy")
+	env.send(req, http.StatusUnprocessableEntity, nil)
+
+	// The first attempt published the task, but died before responding
+	req = env.submitRequest(source)
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := requestFingerprint(req, body)
+	kv, err := env.js.KeyValue(env.workerConfig.KeyValueBucketConfig.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := env.workerConfig.RetryConfig.Policy(nil)
+	records := nats2.NewKeyValueTypedWrapper[cmd.IdempotencyRecord](kv, &common.JsonSerializer[cmd.IdempotencyRecord]{}, policy)
+	_, err = records.Create(cmd.IdempotencyKey("alice", "crashed"), &cmd.IdempotencyRecord{
+		Request: fingerprint,
+		TaskId:  "reserved",
+		Expires: time.Now().Add(time.Hour),
+	}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, &common.JsonSerializer[cmd.RunResult]{}, policy)
+	if _, err = results.Create("reserved", &cmd.RunResult{Status: cmd.Enqueued, Owner: "alice"}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	publisher := nats2.NewPublisherWrapper[cmd.TaskMsg](env.js, &common.JsonSerializer[cmd.TaskMsg]{}, policy)
+	if err = publisher.PublishSync("tasks", &cmd.TaskMsg{KVId: "reserved"}, common.WithMsgId("reserved")); err != nil {
+		t.Fatal(err)
+	}
+
+	req = env.submitRequest(source)
+	req.Header.Set(idempotencyKeyHeader, "crashed")
+	var submission cmd.ApiSubmission
+	env.send(req, http.StatusCreated, &submission)
+	if submission.Id != "reserved" {
+		t.Fatalf("retry got task %s instead of the reserved one", submission.Id)
+	}
+	if n := env.queueLen(); n != 2 {
+		t.Fatalf("expected the retried publish to be deduplicated, got %d queued tasks", n)
+	}
+	if entry, err := results.Get("reserved", context.Background()); err != nil || !entry.Value().Published {
+		t.Fatalf("the retry didn't mark the task published: %v", err)
+	}
+
+	// Once published, repeats don't rely on the duplicate window, which is shorter than the idempotency key ttl
+	_, err = records.Create(cmd.IdempotencyKey("alice", "late"), &cmd.IdempotencyRecord{
+		Request: fingerprint,
+		TaskId:  "published",
+		Expires: time.Now().Add(time.Hour),
+	}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = results.Create("published", &cmd.RunResult{Status: cmd.Enqueued, Owner: "alice", Published: true}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	req = env.submitRequest(source)
+	req.Header.Set(idempotencyKeyHeader, "late")
+	env.send(req, http.StatusCreated, &submission)
+	if submission.Id != "published" {
+		t.Fatalf("late retry got task %s instead of the published one", submission.Id)
+	}
+	if n := env.queueLen(); n != 2 {
+		t.Fatalf("expected the late retry not to publish again, got %d queued tasks", n)
+	}
+}
+
+func (e *testEnv) uploadRequest(filename string, content string, contentType string, class string) *http.Request {
+	e.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if class != "" {
+		if err := form.WriteField("class", class); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filename}))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if _, err = part.Write([]byte(content)); err != nil {
+		e.t.Fatal(err)
+	}
+	if err = form.Close(); err != nil {
+		e.t.Fatal(err)
+	}
+	return e.request(http.MethodPost, "/v1/artifacts", form.FormDataContentType(), &body)
+}
+
+func TestUploadArtifact(t *testing.T) {
+	env := newTestEnv(t)
+	env.startWorkers()
+	const content = "<script>alert(1)</script>"
+
+	var first, repeated, other cmd.ApiArtifact
+	env.send(env.uploadRequest("input.html", content, "text/html", ""), http.StatusCreated, &first)
+	env.send(env.uploadRequest("entrée.txt", content, "text/plain", "source"), http.StatusCreated, &repeated)
+	if first.Id == repeated.Id || first.Digest != repeated.Digest || first.Class != string(cmd.SourceArtifact) {
+		t.Fatalf("repeated upload isn't an artifact of its own: %+v, %+v", first, repeated)
+	}
+	if repeated.Filename != "entrée.txt" || repeated.ContentType != "text/plain" || repeated.Size != uint64(len(content)) {
+		t.Fatalf("repeated upload lost its metadata: %+v", repeated)
+	}
+	var meta cmd.ApiArtifact
+	env.do(http.MethodGet, "/v1/artifacts/"+first.Id+"/meta", "", nil, http.StatusOK, &meta)
+	if meta.Filename != "input.html" || meta.ContentType != "text/html" {
+		t.Fatalf("metadata of the first upload was replaced: %+v", meta)
+	}
+	env.send(env.uploadRequest("input.html", content, "text/html", "output"), http.StatusCreated, &other)
+	if other.Id == first.Id || other.Class != string(cmd.OutputArtifact) {
+		t.Fatalf("upload of another class shares the object: %+v", other)
+	}
+	env.send(env.uploadRequest("input.html", content, "text/html", "result"), http.StatusBadRequest, nil)
+
+	var downloaded []byte
+	header := env.send(env.request(http.MethodGet, "/v1/artifacts/"+first.Id, "", nil), http.StatusOK, &downloaded)
+	if header.Get("X-Content-Type-Options") != "nosniff" || !strings.HasPrefix(header.Get("Content-Disposition"), "attachment") {
+		t.Fatalf("uploaded html could be rendered inline: %v", header)
+	}
+	if string(downloaded) != content {
+		t.Fatalf("unexpected content %q", downloaded)
+	}
+
+	// Tasks get the content of uploads, not the objects keeping their metadata
+	body := fmt.Sprintf(`{"tool":"run","arguments":["<input-file#0>","<input-file#0>","<output-file#0>","<output-file#1>"],`+
+		`"input-files":[{"artifact-id":%q}],"output-file-extensions":[".out",".err"]}`, repeated.Id)
+	var task cmd.ApiTask
+	env.do(http.MethodPost, "/v1/tasks", "application/json", strings.NewReader(body), http.StatusCreated, &task)
+	env.awaitFinal("/v1/tasks/"+task.Id, &task, func() string { return task.Status })
+	if task.Status != cmd.Finished.ToString() || len(task.OutputIds) == 0 || env.download(task.OutputIds[0]) != content {
+		t.Fatalf("task didn't get the uploaded content: %+v", task)
+	}
+}
+
+func TestTaskRequestsStayInTheirDirectory(t *testing.T) {
+	env := newTestEnv(t, func(_ *cmd.WorkerConfig, apiConfig *cmd.ApiConfig) {
+		apiConfig.ToolEnvironment = map[string][]string{"run": {"LANG"}}
+	})
+	rejected := []cmd.ApiTaskRequest{
+		{Tool: "run", Arguments: []string{"/etc/passwd"}},
+		{Tool: "run", Arguments: []string{"--output=/root/.ssh/authorized_keys"}},
+		{Tool: "run", Arguments: []string{"../../worker-config.json"}},
+		{Tool: "run", Arguments: []string{"..\\secret"}},
+		{Tool: "run", Arguments: []string{"~root"}},
+		{Tool: "run", Environment: []string{"BASH_ENV=payload.sh"}},
+		{Tool: "run", Environment: []string{"ENV=payload.sh"}},
+		{Tool: "run", Environment: []string{"GCONV_PATH=."}},
+		{Tool: "run", Environment: []string{"PYTHONSTARTUP=payload.py"}},
+		{Tool: "run", Environment: []string{"LD_PRELOAD=payload.so"}},
+		{Tool: "run", Environment: []string{"LANG=/etc/passwd"}},
+		{Tool: "clang_compile", Environment: []string{"LANG=C"}}, // Allowed for run only
+	}
+	for _, request := range rejected {
+		body, err := json.Marshal(&request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.do(http.MethodPost, "/v1/tasks", "application/json", bytes.NewReader(body), http.StatusBadRequest, nil)
+	}
+
+	body := `{"tool":"run","arguments":["-x","--name=<output-file#0>"],"environment":["LANG=C"],"output-file-extensions":[".txt"]}`
+	env.do(http.MethodPost, "/v1/tasks", "application/json", strings.NewReader(body), http.StatusCreated, nil)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported token algorithm")
+	errBadSignature     = errors.New("bad token signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotYetValid = errors.New("token not yet valid")
+	errBadTokenClaims   = errors.New("unexpected token issuer or audience")
+)
+
+// jwtVerifier checks HS256 and RS256 tokens against locally configured keys only,
+// no key discovery is performed
+type jwtVerifier struct {
+	hmacSecret []byte
+	publicKey  *rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // Either a string or a list of strings
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+func loadRsaPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data in " + path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key in " + path + " is not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(c.Audience, &list) == nil {
+		for _, aud := range list {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *jwtVerifier) verifySignature(alg string, signed string, signature []byte) error {
+	switch {
+	case alg == "HS256" && v.hmacSecret != nil:
+		mac := hmac.New(sha256.New, v.hmacSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errBadSignature
+		}
+		return nil
+	case alg == "RS256" && v.publicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return errBadSignature
+		}
+		return nil
+	}
+	return errUnsupportedAlg
+}
+
+// verify returns the subject of the token
+func (v *jwtVerifier) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errMalformedToken
+	}
+	decode := base64.RawURLEncoding.DecodeString
+
+	rawHeader, err := decode(parts[0])
+	if err != nil {
+		return "", errMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(rawHeader, &header) != nil {
+		return "", errMalformedToken
+	}
+	signature, err := decode(parts[2])
+	if err != nil {
+		return "", errMalformedToken
+	}
+	if err = v.verifySignature(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return "", err
+	}
+
+	rawClaims, err := decode(parts[1])
+	if err != nil {
+		return "", errMalformedToken
+	}
+	var claims jwtClaims
+	if json.Unmarshal(rawClaims, &claims) != nil || claims.Subject == "" {
+		return "", errMalformedToken
+	}
+	if claims.ExpiresAt != nil && now.Unix() >= *claims.ExpiresAt {
+		return "", errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Unix() < *claims.NotBefore {
+		return "", errTokenNotYetValid
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return "", errBadTokenClaims
+	}
+	if v.audience != "" && !claims.hasAudience(v.audience) {
+		return "", errBadTokenClaims
+	}
+	return claims.Subject, nil
+}
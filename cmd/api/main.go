@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"exec/cmd"
 	"exec/common"
-	nats2 "exec/nats"
 	"flag"
 	"github.com/gorilla/mux"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
-	configPath := flag.String("config-file", "worker-config.json", "Path to the worker config file")
+	configPath := flag.String("config-file", "api-config.json", "Path to the api config file")
 	help := flag.Bool("help", false, "Print help")
 	flag.Parse()
 
@@ -23,50 +30,102 @@ func main() {
 	}
 
 	env := cmd.ParseEnvironment(os.Environ())
-	var workerConfig cmd.WorkerConfig
-	common.HandlePanic(cmd.ParseConfigFileWithRespectToEnv(*configPath, env, &workerConfig))
+	var apiConfig cmd.ApiConfig
+	common.HandlePanic(cmd.ParseConfigFileWithRespectToEnv(*configPath, env, &apiConfig))
 
-	nc, err := workerConfig.ConnectionConfig.Connect()
+	nc, err := apiConfig.ConnectionConfig.Connect()
 	common.HandlePanic(err)
 	defer nc.Close()
 
 	js, err := nc.JetStream()
 	common.HandlePanic(err)
 
-	kvb, err := js.KeyValue(workerConfig.KeyValueBucketConfig.Name)
-	common.HandlePanic(err)
-	osb, err := js.ObjectStore(workerConfig.ObjectStoreBucketConfig.Name)
-	common.HandlePanic(err)
-
 	logger := log.New(
 		os.Stderr,
 		"Api: ",
 		log.LstdFlags|log.LUTC|log.Lmsgprefix|log.Lmicroseconds,
 	)
+	conn, err := newConnection(nc, js, &apiConfig, logger)
+	common.HandlePanic(err)
+	r := newRouter(conn)
 
-	conn := &connection{
-		publisher:    nats2.NewPublisherWrapper[cmd.TaskMsg](js, &common.JsonSerializer[cmd.TaskMsg]{}),
-		resultKvb:    nats2.NewKeyValueTypedWrapper[cmd.RunResult](kvb, &common.JsonSerializer[cmd.RunResult]{}),
-		osb:          osb,
-		tasksSubject: workerConfig.ConsumerConfig.StreamName,
-		logger:       logger,
+	// Cancelled on shutdown to end event streams and long-polls, which would otherwise hold it up
+	streamsCtx, cancelStreams := context.WithCancel(context.Background())
+	defer cancelStreams()
+	server := &http.Server{
+		Addr:           apiConfig.ListenAddress,
+		Handler:        r,
+		ReadTimeout:    apiConfig.ReadTimeout.Duration,
+		WriteTimeout:   apiConfig.WriteTimeout.Duration,
+		IdleTimeout:    apiConfig.IdleTimeout.Duration,
+		MaxHeaderBytes: apiConfig.MaxHeaderBytes,
+		ErrorLog:       logger,
+		BaseContext: func(net.Listener) context.Context {
+			return streamsCtx
+		},
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var err error
+		if apiConfig.TlsEnabled() {
+			err = server.ListenAndServeTLS(apiConfig.TlsCertFile, apiConfig.TlsKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			common.HandleErrLog(err, logger)
+		}
+	}()
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	select {
+	case <-signals.Done():
+	case <-stopped:
+		return
 	}
 
+	logger.Printf("Shutting down, waiting for in-flight requests")
+	cancelStreams()
+	shutdownTimeout := apiConfig.ShutdownTimeout.Duration
+	if shutdownTimeout == 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	common.HandleErrLog(server.Shutdown(shutdownCtx), logger)
+	<-stopped
+	// Deferred nc.Close() runs only now, so the finished requests could still reach nats
+}
+
+func newRouter(conn *connection) *mux.Router {
 	r := mux.NewRouter()
-	r.NewRoute().Methods(http.MethodPost).Path("/submit").HandlerFunc(conn.handleSubmit)
+	v1 := conn.v1Endpoints()
+	registerEndpoints(r, append(v1, openApiEndpoint(v1, conn.auth.enabled)), conn.auth, conn.limiter, conn.idempotency)
+
+	// Legacy routes, kept for compatibility
+	r.NewRoute().Methods(http.MethodPost).Path("/submit").HandlerFunc(
+		conn.auth.RequireAuth(conn.idempotency.Idempotent(conn.limiter.Limit(conn.handleSubmit))),
+	)
 	r.NewRoute().Methods(http.MethodGet).Path("/compileStatus").HandlerFunc(
-		RequireKey("id", conn.handleGetCompilationStatus),
+		conn.auth.RequireAuth(RequireKey("id", conn.handleGetCompilationStatus)),
 	)
 	r.NewRoute().Methods(http.MethodPost).Path("/run").HandlerFunc(
-		RequireKey("id", conn.handleRun),
+		conn.auth.RequireAuth(RequireKey("id", conn.idempotency.Idempotent(conn.limiter.Limit(conn.handleRun)))),
 	)
 	r.NewRoute().Methods(http.MethodGet).Path("/runStatus").HandlerFunc(
-		RequireKey("id", conn.handleGetRunStatus),
+		conn.auth.RequireAuth(RequireKey("id", conn.handleGetRunStatus)),
+	)
+	r.NewRoute().Methods(http.MethodGet).Path("/events").HandlerFunc(
+		conn.auth.RequireAuth(RequireKey("id", conn.handleEvents)),
+	)
+	r.NewRoute().Methods(http.MethodGet).Path("/output").HandlerFunc(
+		conn.auth.RequireAuth(RequireKey("id", conn.handleOutputStream)),
 	)
 	r.NewRoute().Methods(http.MethodGet).Path("/downloadArtifact").HandlerFunc(
-		RequireKey("id", conn.handleDownloadArtifact),
+		conn.auth.RequireAuth(RequireKey("id", conn.handleDownloadArtifact)),
 	)
-
-	err = http.ListenAndServe(":8000", r)
-	common.HandleErrLog(err, logger)
+	return r
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"exec/cmd"
+	"exec/common"
+	"github.com/gorilla/mux"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type apiParam struct {
+	Name        string
+	In          string // "query" or "header", path parameters are taken from the path
+	Description string
+	Required    bool
+}
+
+// apiEndpoint describes a route both for the router and the OpenAPI document,
+// so that the two can't drift apart
+type apiEndpoint struct {
+	Method              string
+	Path                string // gorilla/mux template, which is also a valid OpenAPI path
+	Summary             string
+	Params              []apiParam
+	RequestContentType  string // Defaults to application/json
+	RequestBody         any    // Sample value, the schema is derived from its type
+	ResponseStatus      int
+	ResponseContentType string         // Defaults to application/json
+	ResponseBody        any            // Sample value, the schema is derived from its type
+	ExtraErrors         map[int]string // Endpoint specific error statuses and their descriptions
+	Limited             bool           // Subject to rate limits and quotas
+	Idempotent          bool           // Repeats with the same Idempotency-Key header get the first response
+	Public              bool           // Served without authentication
+	Handler             func(http.ResponseWriter, *http.Request)
+}
+
+var idempotencyKeyParam = apiParam{
+	Name:        idempotencyKeyHeader,
+	In:          "header",
+	Description: "Repeats of the request with the same key get the first response instead of creating another task",
+}
+
+var pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+func registerEndpoints(r *mux.Router, endpoints []apiEndpoint, auth *authenticator, limiter *limiter, idempotency *idempotency) {
+	for _, e := range endpoints {
+		handler := e.Handler
+		if e.Limited {
+			handler = limiter.Limit(handler)
+		}
+		if e.Idempotent {
+			// Replays aren't limited
+			handler = idempotency.Idempotent(handler)
+		}
+		if !e.Public {
+			handler = auth.RequireAuth(handler)
+		}
+		r.NewRoute().Methods(e.Method).Path(e.Path).HandlerFunc(handler)
+	}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	formFileType = reflect.TypeOf(formFile{})
+)
+
+// schemaOf follows encoding/json rules for the subset of types used in the api
+func schemaOf(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if t == formFileType {
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]any{}
+}
+
+func contentOf(contentType string, sample any) map[string]any {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return map[string]any{
+		contentType: map[string]any{"schema": schemaOf(reflect.TypeOf(sample))},
+	}
+}
+
+func errorResponse(description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     contentOf("", cmd.ApiError{}),
+	}
+}
+
+func buildOpenApiDocument(endpoints []apiEndpoint, authEnabled bool) map[string]any {
+	paths := make(map[string]any)
+	for _, e := range endpoints {
+		var parameters []any
+		pathParams := pathParamRegexp.FindAllStringSubmatch(e.Path, -1)
+		for _, match := range pathParams {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		params := e.Params
+		if e.Idempotent {
+			params = append(params, idempotencyKeyParam)
+		}
+		for _, p := range params {
+			parameters = append(parameters, map[string]any{
+				"name":        p.Name,
+				"in":          p.In,
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+
+		responses := map[string]any{
+			strconv.Itoa(e.ResponseStatus): map[string]any{
+				"description": http.StatusText(e.ResponseStatus),
+				"content":     contentOf(e.ResponseContentType, e.ResponseBody),
+			},
+			"500": errorResponse("Internal error"),
+		}
+		if len(params) != 0 || e.RequestBody != nil {
+			responses["400"] = errorResponse("Malformed request")
+		}
+		if len(pathParams) != 0 || e.Limited {
+			responses["404"] = errorResponse("Not found or owned by another principal")
+		}
+		if authEnabled && !e.Public {
+			responses["401"] = errorResponse("Missing or invalid credentials")
+		}
+		if e.Idempotent {
+			responses["422"] = errorResponse("Idempotency key was used for another request")
+		}
+		if e.Limited {
+			responses["429"] = errorResponse("Rate limit or daily quota exceeded, see Retry-After")
+		}
+		for status, description := range e.ExtraErrors {
+			responses[strconv.Itoa(status)] = errorResponse(description)
+		}
+
+		operation := map[string]any{
+			"summary":   e.Summary,
+			"responses": responses,
+		}
+		if len(parameters) != 0 {
+			operation["parameters"] = parameters
+		}
+		if e.RequestBody != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  contentOf(e.RequestContentType, e.RequestBody),
+			}
+		}
+		if e.Public {
+			operation["security"] = []any{}
+		}
+
+		item, ok := paths[e.Path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[e.Path] = item
+		}
+		item[strings.ToLower(e.Method)] = operation
+	}
+
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "exec",
+			"version": "1",
+		},
+		"paths": paths,
+	}
+	if authEnabled {
+		document["components"] = map[string]any{
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type": "apiKey",
+					"in":   "header",
+					"name": apiKeyHeader,
+				},
+				"bearer": map[string]any{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		}
+		document["security"] = []any{
+			map[string]any{"apiKey": []any{}},
+			map[string]any{"bearer": []any{}},
+		}
+	}
+	return document
+}
+
+// openApiEndpoint serves the document describing the endpoints along with itself
+func openApiEndpoint(endpoints []apiEndpoint, authEnabled bool) apiEndpoint {
+	e := apiEndpoint{
+		Method:         http.MethodGet,
+		Path:           "/v1/openapi.json",
+		Summary:        "This document",
+		ResponseStatus: http.StatusOK,
+		ResponseBody:   map[string]any{},
+		Public:         true,
+	}
+	data, err := json.MarshalIndent(buildOpenApiDocument(append(endpoints, e), authEnabled), "", "  ")
+	common.HandlePanic(err)
+	e.Handler = func(resp http.ResponseWriter, _ *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusOK)
+		_, _ = resp.Write(data)
+	}
+	return e
+}
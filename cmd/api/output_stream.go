@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"exec/cmd"
+	"exec/common"
+	"net/http"
+	"time"
+)
+
+const (
+	outputChunksBuffer = 64              // How many chunks may wait for a slow client before new ones get dropped
+	outputDrainTimeout = 2 * time.Second // How long chunks in flight are awaited once the task is final
+)
+
+// handleOutputStream relays the output of a running tool as server-sent events:
+// "output" for every chunk with its bytes in base64, "gap" with the count of lost chunks and their bytes
+// when the client connected late or can't keep up, "truncated" when the streamed bytes cap is hit and "eof" once the tool has exited.
+// Final status of the task doesn't end the stream before its eof chunk, which may still be in flight
+func (c *connection) handleOutputStream(resp http.ResponseWriter, req *http.Request) {
+	if !c.streamingConfig.Enabled() {
+		c.returnErrorStr(resp, http.StatusNotFound, "output streaming is disabled")
+		return
+	}
+	id := requestId(req)
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	// Subscribe before looking at the status, so that nothing published in between is missed
+	chunks, err := c.outputListener.Listen(c.streamingConfig.Subject(id), outputChunksBuffer, ctx)
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "error: "+err.Error())
+		return
+	}
+	results, err := c.watchRunResult(ctx, id)
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, "id not found")
+		return
+	}
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "error: "+err.Error())
+		return
+	}
+
+	common.HandleErrLog(disableWriteDeadline(resp), c.logger)
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event string, payload any) bool {
+		data, err := json.Marshal(payload)
+		common.HandleErrLog(err, c.logger)
+		if _, err = common.Fprintfln(resp, "event: %s\ndata: %s\n", event, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	type Output struct {
+		Stream string `json:"stream"`
+		Data   []byte `json:"data"`
+	}
+	type Gap struct {
+		Lost  uint64 `json:"lost"`
+		Bytes uint64 `json:"bytes"`
+	}
+	type Empty struct{}
+
+	limit := c.streamingConfig.StreamedBytesLimit()
+	var streamed int64
+	var nextSeq, nextOffset uint64
+	var final *cmd.RunResult
+	var drainTimeout <-chan time.Time
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return
+			}
+			if chunk.Seq > nextSeq && !send("gap", &Gap{Lost: chunk.Seq - nextSeq, Bytes: chunk.Offset - nextOffset}) {
+				return
+			}
+			nextSeq = chunk.Seq + 1
+			nextOffset = chunk.Offset + uint64(len(chunk.Data))
+			if chunk.Eof {
+				send("eof", &Empty{})
+				return
+			}
+			if chunk.Truncated || streamed+int64(len(chunk.Data)) > limit {
+				send("truncated", &Empty{})
+				return
+			}
+			streamed += int64(len(chunk.Data))
+			if !send("output", &Output{Stream: chunk.Stream, Data: chunk.Data}) {
+				return
+			}
+		case result, ok := <-results:
+			if !ok {
+				results = nil // Either final or purged, chunks may still be in flight
+				if final == nil {
+					drainTimeout = time.After(outputDrainTimeout)
+				}
+				continue
+			}
+			if !result.Status.IsFinal() {
+				continue
+			}
+			final = result
+			if final.StreamedChunks <= nextSeq {
+				// Everything arrived, or nothing was streamed like for cancelled or cached tasks
+				send("eof", &Empty{})
+				return
+			}
+			drainTimeout = time.After(outputDrainTimeout)
+		case <-drainTimeout:
+			// Reports the chunks which never arrived, the eof one carries no output
+			if final != nil && final.StreamedChunks > nextSeq+1 {
+				gap := &Gap{Lost: final.StreamedChunks - nextSeq - 1, Bytes: final.StreamedBytes - nextOffset}
+				if !send("gap", gap) {
+					return
+				}
+			}
+			send("eof", &Empty{})
+			return
+		}
+	}
+}
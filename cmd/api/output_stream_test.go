@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"exec/cmd"
+	"exec/common"
+	"exec/memory"
+	"github.com/gorilla/mux"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// flushRecorder signals the first flush, which happens once the stream is subscribed
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (r *flushRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	select {
+	case <-r.flushed:
+	default:
+		close(r.flushed)
+	}
+}
+
+func newStreamTestConnection(t *testing.T) (*connection, common.Broadcaster[cmd.OutputChunk]) {
+	bus := memory.NewBus()
+	kvb := memory.NewKeyValueBucket[cmd.RunResult](memory.NewKeyValue(), &common.JsonSerializer[cmd.RunResult]{})
+	if _, err := kvb.Create("task", &cmd.RunResult{Status: cmd.Processing}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := &connection{
+		resultKvb:       kvb,
+		logger:          log.New(io.Discard, "", 0),
+		auth:            &authenticator{},
+		outputListener:  memory.NewListener[cmd.OutputChunk](bus, &common.JsonSerializer[cmd.OutputChunk]{}),
+		streamingConfig: cmd.OutputStreamingConfig{SubjectPrefix: "output"},
+	}
+	return c, memory.NewBroadcaster[cmd.OutputChunk](bus, &common.JsonSerializer[cmd.OutputChunk]{})
+}
+
+// streamEvents runs the handler until it returns, publish is called once it listens
+func streamEvents(t *testing.T, c *connection, publish func()) []string {
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/v1/tasks/task/output", nil), map[string]string{"id": "task"})
+	resp := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.handleOutputStream(resp, req)
+	}()
+	<-resp.flushed
+	publish()
+	<-done
+	var events []string
+	for _, line := range strings.Split(resp.Body.String(), "\n") {
+		if line != "" {
+			events = append(events, line)
+		}
+	}
+	return events
+}
+
+func finish(t *testing.T, c *connection, chunks uint64, bytes uint64) {
+	result := &cmd.RunResult{Status: cmd.Finished, StreamedChunks: chunks, StreamedBytes: bytes}
+	entry, err := c.resultKvb.Get("task", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.resultKvb.Update("task", result, entry.Revision(), context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputStreamDrainsChunksAfterFinalStatus(t *testing.T) {
+	c, broadcaster := newStreamTestConnection(t)
+	events := streamEvents(t, c, func() {
+		// The final status overtakes the tail of the output
+		finish(t, c, 3, 2)
+		for _, chunk := range []*cmd.OutputChunk{
+			{Seq: 0, Stream: cmd.StdoutStream, Data: []byte("a")},
+			{Seq: 1, Offset: 1, Stream: cmd.StdoutStream, Data: []byte("b")},
+			{Seq: 2, Offset: 2, Eof: true},
+		} {
+			if err := broadcaster.Broadcast("output.task", chunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	expected := []string{
+		"event: output", `data: {"stream":"stdout","data":"YQ=="}`,
+		"event: output", `data: {"stream":"stdout","data":"Yg=="}`,
+		"event: eof", "data: {}",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("streamed\n%s", strings.Join(events, "\n"))
+	}
+}
+
+func TestOutputStreamReportsLostTail(t *testing.T) {
+	c, broadcaster := newStreamTestConnection(t)
+	events := streamEvents(t, c, func() {
+		err := broadcaster.Broadcast("output.task", &cmd.OutputChunk{Seq: 0, Stream: cmd.StderrStream, Data: []byte("a")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Another chunk of 4 bytes and the eof one never arrive
+		finish(t, c, 3, 5)
+	})
+	expected := []string{
+		"event: output", `data: {"stream":"stderr","data":"YQ=="}`,
+		"event: gap", `data: {"lost":1,"bytes":4}`,
+		"event: eof", "data: {}",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("streamed\n%s", strings.Join(events, "\n"))
+	}
+}
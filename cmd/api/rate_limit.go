@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"math"
+	"net"
+	"net/http"
+	"time"
+)
+
+// tokenBucket is shared between api replicas through the key value bucket
+type tokenBucket struct {
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated-at"`
+}
+
+type limiter struct {
+	config  cmd.RateLimitConfig
+	buckets common.KeyValueBucket[tokenBucket]
+	quotas  common.KeyValueBucket[cmd.QuotaUsage]
+}
+
+// clientKey Anonymous clients are told apart by address
+func clientKey(req *http.Request) string {
+	if principal := principalFrom(req.Context()); principal != "" {
+		return "principal:" + principal
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// take returns zero if a token is granted, otherwise the time until the next token
+func (l *limiter) take(ctx context.Context, client string, now time.Time) (time.Duration, error) {
+	if l.config.RequestsPerSecond <= 0 {
+		return 0, nil
+	}
+	burst := math.Max(float64(l.config.Burst), 1)
+	var retryAfter time.Duration
+	_, err := cmd.Upsert(l.buckets, cmd.RateLimitKeyPrefix+cmd.HashKeyPart(client), func(b *tokenBucket) error {
+		if b.UpdatedAt.IsZero() {
+			b.Tokens = burst
+		} else if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+			b.Tokens = math.Min(burst, b.Tokens+elapsed.Seconds()*l.config.RequestsPerSecond)
+		}
+		b.UpdatedAt = now
+		if b.Tokens >= 1 {
+			b.Tokens--
+			retryAfter = 0
+		} else {
+			retryAfter = time.Duration((1 - b.Tokens) / l.config.RequestsPerSecond * float64(time.Second))
+		}
+		return nil
+	}, ctx)
+	return retryAfter, err
+}
+
+// checkQuota returns zero if the principal hasn't exhausted any of its daily quotas,
+// otherwise the time until they are reset
+func (l *limiter) checkQuota(ctx context.Context, principal string, now time.Time) (time.Duration, error) {
+	if l.config.DailyCpuSeconds <= 0 && l.config.DailyStoredBytes == 0 {
+		return 0, nil
+	}
+	entry, err := l.quotas.Get(cmd.QuotaKey(principal, now), ctx)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	usage := entry.Value()
+	if (l.config.DailyCpuSeconds > 0 && usage.CpuSeconds >= l.config.DailyCpuSeconds) ||
+		(l.config.DailyStoredBytes > 0 && usage.StoredBytes >= l.config.DailyStoredBytes) {
+		return cmd.QuotaResetIn(now), nil
+	}
+	return 0, nil
+}
+
+// chargeStoredBytes is called for artifacts stored by the api itself, the worker charges for its outputs
+func (l *limiter) chargeStoredBytes(ctx context.Context, size uint64) error {
+	return cmd.ChargeQuota(l.quotas, principalFrom(ctx), time.Now(), &cmd.QuotaUsage{StoredBytes: size}, ctx)
+}
@@ -1,42 +1,54 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"exec/cmd"
 	"exec/common"
+	"io"
 	"net/http"
 )
 
-// TODO: Possible failure because of absence of the OS item
-func (c *connection) run(osId string) (string, error) {
-	runId := common.GetRandomId()
-	_, err := c.resultKvb.Create(runId, &cmd.RunResult{
-		Status: cmd.Enqueued,
-	})
+// run executes the binary with an empty stdin unless stdin is given
+func (c *connection) run(ctx context.Context, osId string, stdin io.Reader, notificationUrl string) (string, error) {
+	_, binary, err := c.artifactInfo(ctx, osId)
 	if err != nil {
 		return "", err
 	}
-	task := cmd.TaskMsg{
+
+	task := &cmd.TaskMsg{
 		InputFiles: []cmd.InputFile{
-			{osId, ".cpp"},
+			{ObjectStoreId: binary.Name, Extension: ".cpp"},
 		},
 		OutputFileExtensions: []string{".out", ".log"},
 		Tool:                 "run",
 		Arguments:            []string{"<input-file#0>", "/dev/null", "<output-file#0>", "<output-file#1>"},
 		Environment:          []string{},
-		NotificationUrl:      "",
-		KVId:                 runId,
+		NotificationUrl:      notificationUrl,
 	}
-	err = c.publisher.PublishSync(c.tasksSubject, &task)
-	if err != nil {
-		return "", err
+	if stdin != nil {
+		inOi, err := c.putSource(ctx, stdin)
+		if err != nil {
+			return "", err
+		}
+		task.InputFiles = append(task.InputFiles, cmd.InputFile{ObjectStoreId: inOi.Name, Extension: ".in"})
+		task.Arguments[1] = "<input-file#1>"
 	}
-	return runId, nil
+	return c.enqueue(ctx, task)
 }
 
 func (c *connection) handleRun(resp http.ResponseWriter, req *http.Request) {
 	id := req.URL.Query().Get("id")
-	runId, err := c.run(id)
+	notificationUrl, err := parseNotificationUrl(req.URL.Query().Get("notification-url"))
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	runId, err := c.run(req.Context(), id, nil, notificationUrl)
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, "binary id not found")
+		return
+	}
 	if err != nil {
 		c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
 		return
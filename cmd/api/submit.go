@@ -2,44 +2,61 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"exec/cmd"
 	"exec/common"
-	nats2 "exec/nats"
 	"io"
 	"net/http"
 )
 
-func (c *connection) submit(file io.Reader) (string, string, error) {
-	oi, err := nats2.RobustPutObject(c.osb, file, common.GetRandomId())
+// putSource deduplicates sources and inputs, only the ones actually stored are charged
+func (c *connection) putSource(ctx context.Context, data io.Reader) (*common.ObjectInfo, error) {
+	owner := principalFrom(ctx)
+	oi, uploaded, err := c.contentStore.Put(ctx, data, cmd.ContentScope(owner), cmd.ArtifactMeta("", owner, cmd.SourceArtifact))
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
+	if uploaded {
+		common.HandleErrLog(c.limiter.chargeStoredBytes(ctx, oi.Size), c.logger)
+	}
+	return oi, nil
+}
 
-	id := common.GetRandomId()
-	_, err = c.resultKvb.Create(id, &cmd.RunResult{
-		Status: cmd.Enqueued,
-	})
+// submit returns the task id, the source id and, on a compile cache hit, the result of the already finished task
+func (c *connection) submit(ctx context.Context, file io.Reader, notificationUrl string) (string, string, *cmd.ToolResult, error) {
+	oi, err := c.putSource(ctx, file)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 
-	task := cmd.TaskMsg{
+	task := &cmd.TaskMsg{
 		InputFiles: []cmd.InputFile{
-			{oi.Name, ".cpp"},
+			{ObjectStoreId: oi.Name, Extension: ".cpp"},
 		},
 		OutputFileExtensions: []string{"", ".log"},
+		OutputClasses:        []cmd.ArtifactClass{cmd.BinaryArtifact, cmd.OutputArtifact},
 		Tool:                 "clang_compile",
 		Arguments:            []string{"<input-file#0>", "<output-file#0>", "<output-file#1>"},
 		Environment:          []string{},
-		NotificationUrl:      "",
-		KVId:                 id,
+		NotificationUrl:      notificationUrl,
 	}
-	err = c.publisher.PublishSync(c.tasksSubject, &task)
+	if c.compileCache != nil {
+		task.CacheKey = cmd.CompileCacheKey(task, c.toolchainVersion)
+		id, toolResult, err := c.finishFromCache(ctx, task)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if toolResult != nil {
+			return id, oi.Name, toolResult, nil
+		}
+	}
+	id, err := c.enqueue(ctx, task)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
-	return id, oi.Name, nil
+	return id, oi.Name, nil, nil
 }
 
 const (
@@ -47,42 +64,61 @@ const (
 	maxSourceSize = 64 << 10
 )
 
-func (c *connection) handleSubmit(resp http.ResponseWriter, req *http.Request) {
+// readSubmitForm returns the source file and the notification url, errors come with the http status to respond with
+func readSubmitForm(req *http.Request) (*bytes.Reader, string, int, error) {
 	err := req.ParseMultipartForm(maxMemory)
 	if err != nil {
-		c.returnErrorStr(resp, http.StatusBadRequest, err.Error())
-		return
+		return nil, "", http.StatusBadRequest, err
+	}
+	notificationUrl, err := parseNotificationUrl(req.FormValue("notification-url"))
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
 	}
 	file, fh, err := req.FormFile("file")
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
 	if fh.Size > maxSourceSize {
-		c.returnErrorStr(resp, http.StatusBadRequest, "Max source file size is 64Kb")
-		return
+		return nil, "", http.StatusBadRequest, errors.New("Max source file size is 64Kb")
 	}
 	var buf bytes.Buffer
 	ln, err := io.Copy(&buf, file)
 	if err != nil {
-		c.returnErrorStr(resp, http.StatusInternalServerError, "Failed to load source file: "+err.Error())
-		return
+		return nil, "", http.StatusInternalServerError, errors.New("Failed to load source file: " + err.Error())
 	}
 	if ln != fh.Size {
-		c.returnErrorStr(resp, http.StatusInternalServerError, "Failed to load full file")
+		return nil, "", http.StatusInternalServerError, errors.New("Failed to load full file")
+	}
+	return bytes.NewReader(buf.Bytes()), notificationUrl, http.StatusOK, nil
+}
+
+func (c *connection) handleSubmit(resp http.ResponseWriter, req *http.Request) {
+	source, notificationUrl, status, err := readSubmitForm(req)
+	if err != nil {
+		c.returnErrorStr(resp, status, err.Error())
 		return
 	}
 
-	id, srcId, err := c.submit(&buf)
+	id, srcId, cached, err := c.submit(req.Context(), source, notificationUrl)
 	if err != nil {
 		c.returnErrorStr(resp, http.StatusInternalServerError, "Failed to submit: "+err.Error())
 		return
 	}
 
 	type Ok struct {
-		Id    string `json:"id"`
-		SrcId string `json:"src-id"`
+		Id     string `json:"id"`
+		SrcId  string `json:"src-id"`
+		Status string `json:"status"`
+	}
+	taskStatus := cmd.Enqueued
+	if cached != nil {
+		taskStatus = cmd.Finished
 	}
 	resp.WriteHeader(http.StatusOK)
 	data, err := json.Marshal(&Ok{
-		Id:    id,
-		SrcId: srcId,
+		Id:     id,
+		SrcId:  srcId,
+		Status: taskStatus.ToString(),
 	})
 	common.HandleErrLog(err, c.logger)
 	_, err = resp.Write(data)
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"exec/cmd"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxTaskFiles        = 16
+	maxTaskArguments    = 256
+	maxTaskArgumentSize = 4 << 10
+)
+
+var (
+	extensionRegexp = regexp.MustCompile(`^(\.[A-Za-z0-9_+-]{1,15})*$`)
+	envNameRegexp   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// checkPath rejects absolute paths and parent directories anywhere in s, e.g. in "--output=/etc/passwd",
+// tools may only touch the files of their task
+func checkPath(s string) error {
+	if strings.ContainsAny(s, `/\`) || strings.Contains(s, "..") || strings.HasPrefix(s, "~") {
+		return fmt.Errorf("%q refers to a path outside of the task", s)
+	}
+	return nil
+}
+
+// checkEnvironment accepts only the variables configured for the tool, so e.g. BASH_ENV can't inject code
+func (c *connection) checkEnvironment(tool string, environment []string) error {
+	for _, e := range environment {
+		name, value, ok := strings.Cut(e, "=")
+		if !ok || !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("malformed environment entry %q", e)
+		}
+		if !c.toolEnvironment[tool][name] {
+			return fmt.Errorf("environment variable %s can't be set for %s", name, tool)
+		}
+		if err := checkPath(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTask returns the http status to respond with along with the error
+func (c *connection) buildTask(ctx context.Context, request *cmd.ApiTaskRequest) (*cmd.TaskMsg, int, error) {
+	if !c.allowedTools[request.Tool] || strings.ContainsAny(request.Tool, `/\`) {
+		return nil, http.StatusBadRequest, fmt.Errorf("tool %q is not allowed", request.Tool)
+	}
+	if len(request.InputFiles) > maxTaskFiles || len(request.OutputFileExtensions) > maxTaskFiles {
+		return nil, http.StatusBadRequest, fmt.Errorf("at most %d input and %d output files are allowed", maxTaskFiles, maxTaskFiles)
+	}
+	if len(request.Arguments) > maxTaskArguments {
+		return nil, http.StatusBadRequest, fmt.Errorf("at most %d arguments are allowed", maxTaskArguments)
+	}
+	for _, arg := range append(append([]string{}, request.Arguments...), request.Environment...) {
+		if len(arg) > maxTaskArgumentSize {
+			return nil, http.StatusBadRequest, fmt.Errorf("arguments and environment entries are limited to %d bytes", maxTaskArgumentSize)
+		}
+	}
+	for _, arg := range request.Arguments {
+		if err := checkPath(arg); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	if err := c.checkEnvironment(request.Tool, request.Environment); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	for _, ext := range request.OutputFileExtensions {
+		if !extensionRegexp.MatchString(ext) {
+			return nil, http.StatusBadRequest, fmt.Errorf("malformed extension %q", ext)
+		}
+	}
+	notificationUrl, err := parseNotificationUrl(request.NotificationUrl)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	task := &cmd.TaskMsg{
+		InputFiles:           make([]cmd.InputFile, len(request.InputFiles)),
+		OutputFileExtensions: append([]string{}, request.OutputFileExtensions...),
+		Tool:                 request.Tool,
+		Arguments:            append([]string{}, request.Arguments...),
+		Environment:          append([]string{}, request.Environment...),
+		NotificationUrl:      notificationUrl,
+	}
+	for i, input := range request.InputFiles {
+		if !extensionRegexp.MatchString(input.Extension) {
+			return nil, http.StatusBadRequest, fmt.Errorf("malformed extension %q", input.Extension)
+		}
+		_, content, err := c.artifactInfo(ctx, input.ArtifactId)
+		if isNotFound(err) {
+			return nil, http.StatusNotFound, fmt.Errorf("input artifact %q not found", input.ArtifactId)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		task.InputFiles[i] = cmd.InputFile{
+			ObjectStoreId: content.Name,
+			Extension:     input.Extension,
+		}
+	}
+	if err = task.CheckPlaceholders(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return task, http.StatusOK, nil
+}
+
+func (c *connection) handleCreateTaskV1(resp http.ResponseWriter, req *http.Request) {
+	var request cmd.ApiTaskRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(resp, req.Body, maxJsonBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, "bad request body: "+err.Error())
+		return
+	}
+	task, status, err := c.buildTask(req.Context(), &request)
+	if err != nil {
+		c.returnErrorStr(resp, status, err.Error())
+		return
+	}
+	id, err := c.enqueue(req.Context(), task)
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to enqueue: "+err.Error())
+		return
+	}
+	resp.Header().Set("Location", "/v1/tasks/"+id)
+	c.returnJson(resp, http.StatusCreated, &cmd.ApiTask{
+		Id:     id,
+		Status: cmd.Enqueued.ToString(),
+	})
+}
+
+func (c *connection) handleGetTaskV1(resp http.ResponseWriter, req *http.Request) {
+	c.genericHandleGetStatus(resp, req, "task not found", -1, func(id string, status cmd.RunStatus, result *cmd.ToolResult) any {
+		res := &cmd.ApiTask{
+			Id:     id,
+			Status: status.ToString(),
+		}
+		if result != nil {
+			res.OutputIds = result.OutputFiles
+			res.ToolOutput = result.ToolOutput
+		}
+		return res
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxArtifactSize     = 16 << 20
+	maxArtifactFilename = 255
+)
+
+// uploadableClasses of artifacts, results are only created by workers
+var uploadableClasses = map[cmd.ArtifactClass]bool{
+	cmd.SourceArtifact: true,
+	cmd.BinaryArtifact: true,
+	cmd.OutputArtifact: true,
+}
+
+// formFile is a file part of a multipart form, unlike []byte it isn't base64
+type formFile []byte
+
+// v1ArtifactForm only describes the multipart form for the OpenAPI document
+type v1ArtifactForm struct {
+	File  formFile `json:"file"`
+	Class string   `json:"class,omitempty"` // Decides the retention: source (default), binary or output
+}
+
+// artifactFromInfo describes the artifact oi, content holds its content and is oi itself unless it's an upload
+func artifactFromInfo(oi *common.ObjectInfo, content *common.ObjectInfo) *cmd.ApiArtifact {
+	contentType := oi.Headers.Get(cmd.ObjectContentTypeHeader)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &cmd.ApiArtifact{
+		Id:          oi.Name,
+		Filename:    oi.Headers.Get(cmd.ObjectFilenameHeader),
+		ContentType: contentType,
+		Size:        content.Size,
+		Digest:      content.Digest,
+		Class:       string(cmd.ObjectClass(oi)),
+		Owner:       cmd.ObjectOwner(oi),
+		Created:     cmd.ObjectCreated(oi),
+	}
+}
+
+// handleUploadArtifact stores test inputs, expected outputs, checkers and alike without enqueueing anything.
+// The content type is taken from the form part, or sniffed if it's missing or generic. The content is deduplicated
+// like sources, but every upload is an artifact of its own, which keeps its filename and content type
+func (c *connection) handleUploadArtifact(resp http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(resp, req.Body, maxArtifactSize+maxMemory)
+	if err := req.ParseMultipartForm(maxMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.returnErrorStr(resp, http.StatusRequestEntityTooLarge, fmt.Sprintf("max artifact size is %d bytes", maxArtifactSize))
+			return
+		}
+		c.returnErrorStr(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer func() {
+		common.HandleErrLog(req.MultipartForm.RemoveAll(), c.logger)
+	}()
+	file, fh, err := req.FormFile("file")
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer func() {
+		common.HandleErrLog(file.Close(), c.logger)
+	}()
+	if fh.Size > maxArtifactSize {
+		c.returnErrorStr(resp, http.StatusRequestEntityTooLarge, fmt.Sprintf("max artifact size is %d bytes", maxArtifactSize))
+		return
+	}
+	if len(fh.Filename) > maxArtifactFilename {
+		c.returnErrorStr(resp, http.StatusBadRequest, fmt.Sprintf("max filename length is %d bytes", maxArtifactFilename))
+		return
+	}
+	contentType := fh.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
+			return
+		}
+		contentType = http.DetectContentType(head[:n])
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if _, _, err = mime.ParseMediaType(contentType); err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, "malformed content type: "+err.Error())
+		return
+	}
+	class := cmd.ArtifactClass(req.FormValue("class"))
+	if class == "" {
+		class = cmd.SourceArtifact
+	}
+	if !uploadableClasses[class] {
+		c.returnErrorStr(resp, http.StatusBadRequest, fmt.Sprintf("artifacts of class %q can't be uploaded", class))
+		return
+	}
+
+	owner := principalFrom(req.Context())
+	scope := cmd.ContentScope(owner)
+	if class != cmd.SourceArtifact {
+		// The retention of an object follows its class, so other classes don't share objects with sources
+		scope = strings.TrimPrefix(scope+"-"+string(class), "-")
+	}
+	content, uploaded, err := c.contentStore.Put(req.Context(), file, scope, cmd.ArtifactMeta("", owner, class))
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to store artifact: "+err.Error())
+		return
+	}
+	if uploaded {
+		common.HandleErrLog(c.limiter.chargeStoredBytes(req.Context(), content.Size), c.logger)
+	}
+	meta := cmd.ArtifactMeta(common.GetRandomId(), owner, class)
+	meta.Headers.Set(cmd.ObjectContentHeader, content.Name)
+	meta.Headers.Set(cmd.ObjectContentTypeHeader, contentType)
+	if fh.Filename != "" {
+		meta.Headers.Set(cmd.ObjectFilenameHeader, fh.Filename)
+	}
+	oi, err := c.osb.Put(meta, bytes.NewReader(nil), req.Context())
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to store artifact: "+err.Error())
+		return
+	}
+
+	resp.Header().Set("Location", "/v1/artifacts/"+oi.Name)
+	c.returnJson(resp, http.StatusCreated, artifactFromInfo(oi, content))
+}
+
+func (c *connection) handleGetArtifactMeta(resp http.ResponseWriter, req *http.Request) {
+	oi, content, err := c.artifactInfo(req.Context(), requestId(req))
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, errArtifactNotFound.Error())
+		return
+	}
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		c.returnErrorStr(resp, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.returnJson(resp, http.StatusOK, artifactFromInfo(oi, content))
+}
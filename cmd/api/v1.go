@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"exec/cmd"
+	"io"
+	"net/http"
+)
+
+const (
+	maxJsonBodySize = 64 << 10
+	maxStdinSize    = 1 << 20
+)
+
+// v1SubmissionForm only describes the multipart form for the OpenAPI document
+type v1SubmissionForm struct {
+	File            formFile `json:"file"`
+	NotificationUrl string   `json:"notification-url,omitempty"`
+}
+
+var waitParam = apiParam{
+	Name:        "wait",
+	In:          "query",
+	Description: "Long-poll up to this duration (e.g. 30s) for the task to finish",
+}
+
+func (c *connection) v1Endpoints() []apiEndpoint {
+	return []apiEndpoint{
+		{
+			Method:             http.MethodPost,
+			Path:               "/v1/submissions",
+			Summary:            "Upload a source file and enqueue its compilation",
+			RequestContentType: "multipart/form-data",
+			RequestBody:        v1SubmissionForm{},
+			ResponseStatus:     http.StatusCreated,
+			ResponseBody:       cmd.ApiSubmission{},
+			Limited:            true,
+			Idempotent:         true,
+			Handler:            c.handleCreateSubmissionV1,
+		},
+		{
+			Method:         http.MethodGet,
+			Path:           "/v1/submissions/{id}",
+			Summary:        "Get the compilation status and its artifacts",
+			Params:         []apiParam{waitParam},
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiSubmission{},
+			Handler:        c.handleGetSubmissionV1,
+		},
+		{
+			Method:         http.MethodDelete,
+			Path:           "/v1/submissions/{id}",
+			Summary:        "Cancel the compilation, killing the tool if it's already running",
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiTask{},
+			ExtraErrors:    map[int]string{http.StatusConflict: "Already finished"},
+			Handler:        c.handleCancelV1,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/submissions/{id}/events",
+			Summary:             "Stream status transitions of the compilation as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleEvents,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/submissions/{id}/output",
+			Summary:             "Stream compiler output as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleOutputStream,
+		},
+		{
+			Method:         http.MethodPost,
+			Path:           "/v1/runs",
+			Summary:        "Enqueue a run of a compiled binary",
+			RequestBody:    cmd.ApiRunRequest{},
+			ResponseStatus: http.StatusCreated,
+			ResponseBody:   cmd.ApiRun{},
+			Limited:        true,
+			Idempotent:     true,
+			ExtraErrors:    map[int]string{http.StatusRequestEntityTooLarge: "Stdin is too large"},
+			Handler:        c.handleCreateRunV1,
+		},
+		{
+			Method:         http.MethodGet,
+			Path:           "/v1/runs/{id}",
+			Summary:        "Get the run status and its artifacts",
+			Params:         []apiParam{waitParam},
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiRun{},
+			Handler:        c.handleGetRunV1,
+		},
+		{
+			Method:         http.MethodDelete,
+			Path:           "/v1/runs/{id}",
+			Summary:        "Cancel the run, killing the tool if it's already running",
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiTask{},
+			ExtraErrors:    map[int]string{http.StatusConflict: "Already finished"},
+			Handler:        c.handleCancelV1,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/runs/{id}/events",
+			Summary:             "Stream status transitions of the run as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleEvents,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/runs/{id}/output",
+			Summary:             "Stream output of the running binary as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleOutputStream,
+		},
+		{
+			Method:         http.MethodPost,
+			Path:           "/v1/tasks",
+			Summary:        "Enqueue an arbitrary task for one of the allowed tools",
+			RequestBody:    cmd.ApiTaskRequest{},
+			ResponseStatus: http.StatusCreated,
+			ResponseBody:   cmd.ApiTask{},
+			Limited:        true,
+			Idempotent:     true,
+			Handler:        c.handleCreateTaskV1,
+		},
+		{
+			Method:         http.MethodGet,
+			Path:           "/v1/tasks/{id}",
+			Summary:        "Get the task status and ids of all of its outputs",
+			Params:         []apiParam{waitParam},
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiTask{},
+			Handler:        c.handleGetTaskV1,
+		},
+		{
+			Method:         http.MethodDelete,
+			Path:           "/v1/tasks/{id}",
+			Summary:        "Cancel the task, killing the tool if it's already running",
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiTask{},
+			ExtraErrors:    map[int]string{http.StatusConflict: "Already finished"},
+			Handler:        c.handleCancelV1,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/tasks/{id}/events",
+			Summary:             "Stream status transitions of the task as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleEvents,
+		},
+		{
+			Method:              http.MethodGet,
+			Path:                "/v1/tasks/{id}/output",
+			Summary:             "Stream output of the tool as server-sent events",
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "text/event-stream",
+			ResponseBody:        "",
+			Handler:             c.handleOutputStream,
+		},
+		{
+			Method:             http.MethodPost,
+			Path:               "/v1/artifacts",
+			Summary:            "Upload an artifact, e.g. a test input or a checker, to be used by tasks",
+			RequestContentType: "multipart/form-data",
+			RequestBody:        v1ArtifactForm{},
+			ResponseStatus:     http.StatusCreated,
+			ResponseBody:       cmd.ApiArtifact{},
+			Limited:            true,
+			ExtraErrors:        map[int]string{http.StatusRequestEntityTooLarge: "Artifact is too large"},
+			Handler:            c.handleUploadArtifact,
+		},
+		{
+			Method:         http.MethodGet,
+			Path:           "/v1/artifacts/{id}/meta",
+			Summary:        "Get the metadata of an artifact",
+			ResponseStatus: http.StatusOK,
+			ResponseBody:   cmd.ApiArtifact{},
+			Handler:        c.handleGetArtifactMeta,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/v1/artifacts/{id}",
+			Summary: "Download an artifact, or a single range of it",
+			Params: []apiParam{
+				{Name: "Range", In: "header", Description: "Single byte range, e.g. bytes=-4096 for the tail, answered with 206"},
+				{Name: "If-None-Match", In: "header", Description: "ETag of a cached copy, answered with 304 if it's still current"},
+				{Name: "Accept-Encoding", In: "header", Description: "Whole artifacts are gzipped if it includes gzip"},
+			},
+			ResponseStatus:      http.StatusOK,
+			ResponseContentType: "application/octet-stream",
+			ResponseBody:        []byte{},
+			ExtraErrors:         map[int]string{http.StatusRequestedRangeNotSatisfiable: "Range is out of the artifact"},
+			Handler:             c.handleDownloadArtifact,
+		},
+	}
+}
+
+func (c *connection) handleCreateSubmissionV1(resp http.ResponseWriter, req *http.Request) {
+	source, notificationUrl, status, err := readSubmitForm(req)
+	if err != nil {
+		c.returnErrorStr(resp, status, err.Error())
+		return
+	}
+	id, srcId, cached, err := c.submit(req.Context(), source, notificationUrl)
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to submit: "+err.Error())
+		return
+	}
+	submission := &cmd.ApiSubmission{
+		Id:       id,
+		Status:   cmd.Enqueued.ToString(),
+		SourceId: srcId,
+	}
+	if cached != nil {
+		submission.Status = cmd.Finished.ToString()
+		submission.BinaryId = cached.OutputFiles[0]
+		submission.LogId = cached.OutputFiles[1]
+		submission.Stats = cached.ToolOutput
+	}
+	resp.Header().Set("Location", "/v1/submissions/"+id)
+	c.returnJson(resp, http.StatusCreated, submission)
+}
+
+func (c *connection) handleGetSubmissionV1(resp http.ResponseWriter, req *http.Request) {
+	c.genericHandleGetStatus(resp, req, "submission not found", 2, func(id string, status cmd.RunStatus, result *cmd.ToolResult) any {
+		res := &cmd.ApiSubmission{
+			Id:     id,
+			Status: status.ToString(),
+		}
+		if result != nil {
+			res.BinaryId = result.OutputFiles[0]
+			res.LogId = result.OutputFiles[1]
+			res.Stats = result.ToolOutput
+		}
+		return res
+	})
+}
+
+func (c *connection) handleCreateRunV1(resp http.ResponseWriter, req *http.Request) {
+	var runRequest cmd.ApiRunRequest
+	// Leaves room for the stdin, which grows by a third in base64
+	if err := json.NewDecoder(http.MaxBytesReader(resp, req.Body, maxJsonBodySize+int64(base64.StdEncoding.EncodedLen(maxStdinSize)))).Decode(&runRequest); err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, "bad request body: "+err.Error())
+		return
+	}
+	if runRequest.BinaryId == "" {
+		c.returnErrorStr(resp, http.StatusBadRequest, "binary-id is required")
+		return
+	}
+	notificationUrl, err := parseNotificationUrl(runRequest.NotificationUrl)
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusBadRequest, err.Error())
+		return
+	}
+	var stdin io.Reader
+	if runRequest.Stdin != nil {
+		if len(runRequest.Stdin) > maxStdinSize {
+			c.returnErrorStr(resp, http.StatusRequestEntityTooLarge, "stdin is too large")
+			return
+		}
+		stdin = bytes.NewReader(runRequest.Stdin)
+	}
+	id, err := c.run(req.Context(), runRequest.BinaryId, stdin, notificationUrl)
+	if isNotFound(err) {
+		c.returnErrorStr(resp, http.StatusNotFound, "binary not found")
+		return
+	}
+	if err != nil {
+		c.returnErrorStr(resp, http.StatusInternalServerError, "failed to run: "+err.Error())
+		return
+	}
+	resp.Header().Set("Location", "/v1/runs/"+id)
+	c.returnJson(resp, http.StatusCreated, &cmd.ApiRun{
+		Id:     id,
+		Status: cmd.Enqueued.ToString(),
+	})
+}
+
+func (c *connection) handleGetRunV1(resp http.ResponseWriter, req *http.Request) {
+	c.genericHandleGetStatus(resp, req, "run not found", 2, func(id string, status cmd.RunStatus, result *cmd.ToolResult) any {
+		res := &cmd.ApiRun{
+			Id:     id,
+			Status: status.ToString(),
+		}
+		if result != nil {
+			res.StdoutId = result.OutputFiles[0]
+			res.StderrId = result.OutputFiles[1]
+			res.Stats = result.ToolOutput
+		}
+		return res
+	})
+}
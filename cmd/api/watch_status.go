@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"exec/cmd"
+	"exec/common"
+	"time"
+)
+
+// maxStatusWait caps the wait parameter of status long-polling
+const maxStatusWait = time.Minute
+
+// watchRunResult sends every status of the task starting with the current one.
+// The channel is closed after the task reaches a final status, or once ctx is done
+func (c *connection) watchRunResult(ctx context.Context, id string) (<-chan *cmd.RunResult, error) {
+	// Watch doesn't report missing keys, so check existence beforehand
+	entry, err := c.resultKvb.Get(id, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.auth.checkOwnership(ctx, entry.Value().Owner); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	entries, err := c.resultKvb.Watch(id, ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	ch := make(chan *cmd.RunResult)
+	go func() {
+		defer close(ch)
+		defer cancel()
+		for entry := range entries {
+			if entry.Operation() != common.KeyValuePut {
+				return // Purged, there won't be any more updates
+			}
+			select {
+			case ch <- entry.Value():
+			case <-ctx.Done():
+				return
+			}
+			if entry.Value().Status.IsFinal() {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// awaitRunResult returns as soon as the task reaches a final status or wait has elapsed,
+// whichever comes first. Zero wait returns the current status immediately
+func (c *connection) awaitRunResult(ctx context.Context, id string, wait time.Duration) (*cmd.RunResult, error) {
+	if wait == 0 {
+		entry, err := c.resultKvb.Get(id, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.auth.checkOwnership(ctx, entry.Value().Owner); err != nil {
+			return nil, err
+		}
+		return entry.Value(), nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	results, err := c.watchRunResult(waitCtx, id)
+	if err != nil {
+		return nil, err
+	}
+	var last *cmd.RunResult
+	for result := range results {
+		last = result
+	}
+	if last == nil {
+		// Deadline hit before the watcher delivered anything, waitCtx is done by now
+		entry, err := c.resultKvb.Get(id, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return entry.Value(), nil
+	}
+	return last, nil
+}
+
+func parseWait(rawWait string) (time.Duration, error) {
+	if rawWait == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(rawWait)
+	if err != nil {
+		return 0, err
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxStatusWait {
+		wait = maxStatusWait
+	}
+	return wait, nil
+}
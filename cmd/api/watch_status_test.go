@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"exec/cmd"
+	"exec/common"
+	"exec/memory"
+	"testing"
+	"time"
+)
+
+// blockingKeyValueBucket never delivers the current entry to watchers, and fails calls with done contexts like nats does
+type blockingKeyValueBucket struct {
+	common.KeyValueBucket[cmd.RunResult]
+}
+
+func (b *blockingKeyValueBucket) Get(key string, ctx context.Context) (common.KeyValueEntry[cmd.RunResult], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.KeyValueBucket.Get(key, ctx)
+}
+
+func (b *blockingKeyValueBucket) Watch(_ string, ctx context.Context) (<-chan common.KeyValueEntry[cmd.RunResult], error) {
+	ch := make(chan common.KeyValueEntry[cmd.RunResult])
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func TestAwaitRunResultReturnsCurrentStatusAfterWait(t *testing.T) {
+	kvb := memory.NewKeyValueBucket[cmd.RunResult](memory.NewKeyValue(), &common.JsonSerializer[cmd.RunResult]{})
+	if _, err := kvb.Create("task", &cmd.RunResult{Status: cmd.Processing}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := &connection{resultKvb: &blockingKeyValueBucket{kvb}, auth: &authenticator{}}
+	result, err := c.awaitRunResult(context.Background(), "task", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != cmd.Processing {
+		t.Fatalf("got status %s", result.Status.ToString())
+	}
+}
@@ -0,0 +1,32 @@
+package cmd
+
+type ApiConfig struct {
+	ListenAddress       string              `json:"listen-address"`
+	TlsCertFile         string              `json:"tls-cert-file"` // Serves plain http if either of cert and key is empty
+	TlsKeyFile          string              `json:"tls-key-file"`
+	ReadTimeout         Duration            `json:"read-timeout"`
+	WriteTimeout        Duration            `json:"write-timeout"` // Not applied to event streams and long-polling
+	IdleTimeout         Duration            `json:"idle-timeout"`
+	MaxHeaderBytes      int                 `json:"max-header-bytes"`
+	ShutdownTimeout     Duration            `json:"shutdown-timeout"` // How long in-flight requests may take after SIGTERM
+	TasksSubject        string              `json:"tasks-subject"`
+	AllowedTools        []string            `json:"allowed-tools"`        // Tools available through the generic tasks endpoint
+	ToolEnvironment     map[string][]string `json:"tool-environment"`     // Environment variables each allowed tool accepts, none if missing
+	IdempotencyKeyTtl   Duration            `json:"idempotency-key-ttl"`  // How long repeats with the same Idempotency-Key header get the first response, zero ignores the header
+	SerializationFormat string              `json:"serialization-format"` // Of tasks, results and tool results, all formats are read
+
+	ConnectionConfig        ConnectionConfig        `json:"connection-config"`
+	ObjectStoreBucketConfig ObjectStoreBucketConfig `json:"object-store-bucket-config"`
+	ObjectStoreConfig       ObjectStoreConfig       `json:"object-store-config"`
+	KeyValueBucketConfig    KeyValueBucketConfig    `json:"key-value-bucket-config"`
+	OutputStreamingConfig   OutputStreamingConfig   `json:"output-streaming-config"`
+	AuthConfig              AuthConfig              `json:"auth-config"`
+	RateLimitConfig         RateLimitConfig         `json:"rate-limit-config"`
+	NotificationConfig      NotificationConfig      `json:"notification-config"` // Used for compile cache hits, which never reach workers
+	CompileCacheConfig      CompileCacheConfig      `json:"compile-cache-config"`
+	RetryConfig             RetryConfig             `json:"retry-config"`
+}
+
+func (config *ApiConfig) TlsEnabled() bool {
+	return config.TlsCertFile != "" && config.TlsKeyFile != ""
+}
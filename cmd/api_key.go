@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ApiKeyRecord is stored in the api keys bucket under HashApiKey(key)
+type ApiKeyRecord struct {
+	Principal string `json:"principal"`
+	Disabled  bool   `json:"disabled,omitempty"`
+}
+
+// HashApiKey Plain api keys are never stored, neither in the config nor in the bucket
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
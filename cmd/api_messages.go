@@ -0,0 +1,66 @@
+package cmd
+
+import "time"
+
+// Request and response bodies of the v1 api, shared by the api and its clients
+
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+type ApiSubmission struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	SourceId string `json:"source-id,omitempty"` // Only returned on creation
+	BinaryId string `json:"binary-id,omitempty"`
+	LogId    string `json:"log-id,omitempty"`
+	Stats    string `json:"stats,omitempty"`
+}
+
+type ApiRunRequest struct {
+	BinaryId        string `json:"binary-id"`
+	Stdin           []byte `json:"stdin,omitempty"` // Base64, empty if not set
+	NotificationUrl string `json:"notification-url,omitempty"`
+}
+
+type ApiRun struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	StdoutId string `json:"stdout-id,omitempty"`
+	StderrId string `json:"stderr-id,omitempty"`
+	Stats    string `json:"stats,omitempty"`
+}
+
+type ApiTaskInput struct {
+	ArtifactId string `json:"artifact-id"`
+	Extension  string `json:"extension,omitempty"`
+}
+
+type ApiTaskRequest struct {
+	Tool                 string         `json:"tool"`
+	Arguments            []string       `json:"arguments,omitempty"`   // May contain <input-file#N> and <output-file#N> placeholders, but no paths
+	Environment          []string       `json:"environment,omitempty"` // Only the variables configured for the tool
+	InputFiles           []ApiTaskInput `json:"input-files,omitempty"`
+	OutputFileExtensions []string       `json:"output-file-extensions,omitempty"`
+	NotificationUrl      string         `json:"notification-url,omitempty"`
+}
+
+// ApiTask is the generic view of any task, submissions and runs included
+type ApiTask struct {
+	Id         string   `json:"id"`
+	Status     string   `json:"status"`
+	OutputIds  []string `json:"output-ids,omitempty"` // In order of output-file-extensions, empty for files the tool didn't create
+	ToolOutput string   `json:"tool-output,omitempty"`
+}
+
+// ApiArtifact is the metadata of a stored artifact
+type ApiArtifact struct {
+	Id          string    `json:"id"`
+	Filename    string    `json:"filename,omitempty"` // Only set for uploaded artifacts
+	ContentType string    `json:"content-type"`
+	Size        uint64    `json:"size"`
+	Digest      string    `json:"digest"`
+	Class       string    `json:"class,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Created     time.Time `json:"created"`
+}
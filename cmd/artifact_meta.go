@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"exec/common"
+	"net/http"
+	"time"
+)
+
+// ObjectOwnerHeader of an object in the object store holds the principal it belongs to
+const ObjectOwnerHeader = "Exec-Owner"
+
+// ObjectClassHeader of an object in the object store holds its ArtifactClass, which decides its retention
+const ObjectClassHeader = "Exec-Class"
+
+// Headers describing artifacts uploaded by users, Content-Type is also served on download
+const (
+	ObjectFilenameHeader    = "Exec-Filename"
+	ObjectContentTypeHeader = "Content-Type"
+)
+
+// ObjectCreatedHeader holds the RFC 3339 time the artifact was stored at
+const ObjectCreatedHeader = "Exec-Created"
+
+// ObjectContentHeader of an uploaded artifact holds the name of the content addressed object with its content.
+// Uploads are deduplicated, yet each upload is an empty object of its own, so it keeps its own metadata
+const ObjectContentHeader = "Exec-Content"
+
+type ArtifactClass string
+
+const (
+	SourceArtifact ArtifactClass = "source" // Uploaded by users, like sources and stdin
+	BinaryArtifact ArtifactClass = "binary"
+	OutputArtifact ArtifactClass = "output" // Any other file created by a tool, like logs and stdout
+	ResultArtifact ArtifactClass = "result" // ToolResult
+)
+
+func ArtifactMeta(name string, owner string, class ArtifactClass) *common.ObjectMeta {
+	meta := &common.ObjectMeta{Name: name, Headers: http.Header{}}
+	meta.Headers.Set(ObjectClassHeader, string(class))
+	meta.Headers.Set(ObjectCreatedHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	if owner != "" {
+		meta.Headers.Set(ObjectOwnerHeader, owner)
+	}
+	return meta
+}
+
+// ContentScope of content addressed objects of the owner, so owners can't probe each other's uploads
+func ContentScope(owner string) string {
+	if owner == "" {
+		return ""
+	}
+	return HashKeyPart(owner)
+}
+
+func ObjectOwner(info *common.ObjectInfo) string {
+	return info.Headers.Get(ObjectOwnerHeader)
+}
+
+// ObjectClass is empty for objects stored before classes were introduced
+func ObjectClass(info *common.ObjectInfo) ArtifactClass {
+	return ArtifactClass(info.Headers.Get(ObjectClassHeader))
+}
+
+// ObjectCreated falls back to the modification time for objects stored before it was recorded
+func ObjectCreated(info *common.ObjectInfo) time.Time {
+	created, err := time.Parse(time.RFC3339Nano, info.Headers.Get(ObjectCreatedHeader))
+	if err != nil {
+		return info.ModTime
+	}
+	return created
+}
+
+// ObjectContent is the name of the object holding the content, the object itself unless it's an uploaded artifact
+func ObjectContent(info *common.ObjectInfo) string {
+	if content := info.Headers.Get(ObjectContentHeader); content != "" {
+		return content
+	}
+	return info.Name
+}
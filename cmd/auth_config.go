@@ -0,0 +1,41 @@
+package cmd
+
+import "errors"
+
+type ApiKeyConfig struct {
+	Principal string `json:"principal"`
+	KeySha256 string `json:"key-sha256"` // Hex encoded, see HashApiKey
+}
+
+// AuthConfig needs api keys, a keys bucket or jwt keys unless authentication is disabled explicitly,
+// every request is then served on behalf of the anonymous principal
+type AuthConfig struct {
+	Disabled            bool                 `json:"disabled"`
+	ApiKeys             []ApiKeyConfig       `json:"api-keys,omitempty"`
+	ApiKeysBucketConfig KeyValueBucketConfig `json:"api-keys-bucket-config"` // Keys are HashApiKey results, empty name disables the bucket
+	JwtHmacSecret       string               `json:"jwt-hmac-secret"`        // Verification key for HS256 tokens
+	JwtPublicKeyFile    string               `json:"jwt-public-key-file"`    // PEM encoded RSA key for RS256 tokens
+	JwtIssuer           string               `json:"jwt-issuer"`             // Checked against "iss" claim if not empty
+	JwtAudience         string               `json:"jwt-audience"`           // Checked against "aud" claim if not empty
+}
+
+var errNoCredentials = errors.New("auth-config has no credentials, set \"disabled\": true to serve requests without authentication")
+
+func (config *AuthConfig) Enabled() bool {
+	return !config.Disabled
+}
+
+// Check fails closed, so that a config missing its credentials doesn't serve everything to everyone
+func (config *AuthConfig) Check() error {
+	if config.Enabled() && !config.hasCredentials() {
+		return errNoCredentials
+	}
+	return nil
+}
+
+func (config *AuthConfig) hasCredentials() bool {
+	return len(config.ApiKeys) != 0 ||
+		config.ApiKeysBucketConfig.Name != "" ||
+		config.JwtHmacSecret != "" ||
+		config.JwtPublicKeyFile != ""
+}
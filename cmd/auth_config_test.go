@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthConfigFailsClosed(t *testing.T) {
+	if err := (&AuthConfig{}).Check(); !errors.Is(err, errNoCredentials) {
+		t.Fatalf("empty config got %v", err)
+	}
+	if config := (&AuthConfig{Disabled: true}); config.Check() != nil || config.Enabled() {
+		t.Fatal("explicitly disabled authentication was refused")
+	}
+	if config := (&AuthConfig{JwtHmacSecret: "secret"}); config.Check() != nil || !config.Enabled() {
+		t.Fatal("config with credentials was refused")
+	}
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// CompileCacheEntry points at the ToolResult, and so the binary and log, of a previous compilation
+type CompileCacheEntry struct {
+	ToolResultId string `json:"result-id"`
+}
+
+// CompileCacheKey hashes everything the result of the task depends on. Sources are content addressed
+// and scoped by owner, so their names stand for their content and owners never share entries
+func CompileCacheKey(task *TaskMsg, toolchainVersion string) string {
+	data, err := json.Marshal([]any{
+		toolchainVersion,
+		task.Tool,
+		task.Arguments,
+		task.Environment,
+		task.InputFiles,
+		task.OutputFileExtensions,
+	})
+	if err != nil {
+		panic(err) // Only plain strings are marshalled
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,12 @@
+package cmd
+
+// CompileCacheConfig is shared by the api, which looks compilations up, and workers, which store them
+type CompileCacheConfig struct {
+	KeyValueBucketConfig KeyValueBucketConfig `json:"key-value-bucket-config"` // Caching is disabled if the name is empty
+	Ttl                  Duration             `json:"ttl"`                     // Keep it below the retention of binaries and results
+	ToolchainVersion     string               `json:"toolchain-version"`       // Part of the cache key, change it on compiler upgrades
+}
+
+func (c *CompileCacheConfig) Enabled() bool {
+	return c.KeyValueBucketConfig.Name != ""
+}
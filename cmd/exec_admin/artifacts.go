@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"exec/cmd"
+	nats2 "exec/nats"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func (a *admin) artifacts(args []string) error {
+	fs := flag.NewFlagSet("artifacts", flag.ContinueOnError)
+	owner := fs.String("owner", "", "Only artifacts of this principal")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	infos, err := a.osb.List(context.Background())
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime.Before(infos[j].ModTime)
+	})
+	var rows [][]string
+	var total uint64
+	for _, info := range infos {
+		if *owner != "" && cmd.ObjectOwner(info) != *owner {
+			continue
+		}
+		total += info.Size
+		referenced := ""
+		if nats2.IsContentAddressed(info.Name) {
+			if r, err := a.contents.Refs(context.Background(), info.Name); err == nil {
+				referenced = r.LastReferenced.UTC().Format(time.RFC3339)
+			}
+		}
+		rows = append(rows, []string{
+			info.Name,
+			strconv.FormatUint(info.Size, 10),
+			string(cmd.ObjectClass(info)),
+			cmd.ObjectOwner(info),
+			info.ModTime.UTC().Format(time.RFC3339),
+			referenced,
+		})
+	}
+	printTable([]string{"NAME", "SIZE", "CLASS", "OWNER", "MODIFIED", "REFERENCED"}, rows)
+	fmt.Printf("\n%d objects, %d bytes\n", len(rows), total)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"flag"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: %s [flags] <command> [arguments]
+
+Commands:
+  queue [-list]                   Stream depth and consumer pending/ack-pending counts
+  tasks [-status s] [-owner o]    List tasks, optionally filtered
+  show <id>                       Show a task and its full tool result
+  history <id>                    List the statuses a task went through
+  purge [-artifacts] <id>...      Cancel tasks and delete them with their queued messages
+  requeue <id>...                 Publish the queued messages of tasks again, e.g. after max deliveries
+  artifacts [-owner o]            List objects in the artifacts bucket with sizes
+
+Flags:`
+
+// admin goes straight to nats, so it sees every owner's tasks
+type admin struct {
+	js          nats.JetStreamContext
+	config      *cmd.WorkerConfig
+	resultKvb   common.KeyValueBucket[cmd.RunResult]
+	osb         common.ObjectStore
+	contents    *nats2.ContentStore
+	serializers *cmd.Serializers
+
+	retryPolicy *nats2.RetryPolicy
+}
+
+func main() {
+	configPath := flag.String("config-file", "worker-config.json", "Path to the worker config file")
+	help := flag.Bool("help", false, "Print help")
+	flag.Usage = func() {
+		common.Fprintfln(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *help || flag.NArg() == 0 {
+		flag.Usage()
+		return
+	}
+
+	env := cmd.ParseEnvironment(os.Environ())
+	var workerConfig cmd.WorkerConfig
+	common.HandlePanic(cmd.ParseConfigFileWithRespectToEnv(*configPath, env, &workerConfig))
+	serializers, err := cmd.NewSerializers(workerConfig.SerializationFormat)
+	common.HandlePanic(err)
+
+	nc, err := workerConfig.ConnectionConfig.Connect()
+	common.HandlePanic(err)
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	common.HandlePanic(err)
+
+	kv, err := js.KeyValue(workerConfig.KeyValueBucketConfig.Name)
+	common.HandlePanic(err)
+	retryPolicy := workerConfig.RetryConfig.Policy(log.New(os.Stderr, "", 0))
+	osb, err := cmd.OpenObjectStore(js, &workerConfig.ObjectStoreConfig, &workerConfig.ObjectStoreBucketConfig, retryPolicy)
+	common.HandlePanic(err)
+	a := &admin{
+		js:          js,
+		config:      &workerConfig,
+		resultKvb:   nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, serializers.Result, retryPolicy),
+		osb:         osb,
+		contents:    nats2.NewContentStore(osb, kv, retryPolicy),
+		retryPolicy: retryPolicy,
+		serializers: serializers,
+	}
+	commands := map[string]func(args []string) error{
+		"queue":     a.queue,
+		"tasks":     a.tasks,
+		"show":      a.show,
+		"history":   a.history,
+		"purge":     a.purge,
+		"requeue":   a.requeue,
+		"artifacts": a.artifacts,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		common.Fprintfln(os.Stderr, "Unknown command %q", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err = command(flag.Args()[1:]); err != nil {
+		common.Fprintfln(os.Stderr, "%s: %v", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func printTable(headers []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	common.HandlePanic(w.Flush())
+}
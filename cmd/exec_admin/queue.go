@@ -0,0 +1,87 @@
+package main
+
+import (
+	"exec/cmd"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+func (a *admin) scanQueue(visit func(*cmd.QueuedTask) bool) error {
+	return cmd.ScanQueue(a.js, a.config.ConsumerConfig.StreamName, a.serializers.Task, visit)
+}
+
+// findQueued returns the queued messages of the given tasks, tasks without one are missing from the result
+func (a *admin) findQueued(ids []string) (map[string]*cmd.QueuedTask, error) {
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	found := make(map[string]*cmd.QueuedTask)
+	err := a.scanQueue(func(msg *cmd.QueuedTask) bool {
+		if wanted[msg.Task.KVId] {
+			found[msg.Task.KVId] = msg
+		}
+		return len(found) != len(wanted)
+	})
+	return found, err
+}
+
+func (a *admin) queue(args []string) error {
+	fs := flag.NewFlagSet("queue", flag.ContinueOnError)
+	list := fs.Bool("list", false, "Also list the queued messages")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	consumerConfig := a.config.ConsumerConfig
+	stream, err := a.js.StreamInfo(consumerConfig.StreamName)
+	if err != nil {
+		return err
+	}
+	consumer, err := a.js.ConsumerInfo(consumerConfig.StreamName, consumerConfig.Name)
+	if err != nil {
+		return err
+	}
+	printTable(
+		[]string{"STREAM", "MESSAGES", "BYTES", "CONSUMER", "PENDING", "ACK-PENDING", "REDELIVERED", "WAITING-PULLS"},
+		[][]string{{
+			stream.Config.Name,
+			strconv.FormatUint(stream.State.Msgs, 10),
+			strconv.FormatUint(stream.State.Bytes, 10),
+			consumer.Name,
+			strconv.FormatUint(consumer.NumPending, 10),
+			strconv.Itoa(consumer.NumAckPending),
+			strconv.Itoa(consumer.NumRedelivered),
+			strconv.Itoa(consumer.NumWaiting),
+		}},
+	)
+	if !*list {
+		return nil
+	}
+
+	var rows [][]string
+	err = a.scanQueue(func(msg *cmd.QueuedTask) bool {
+		// Acked messages are removed from the work queue, the rest may be delivered and waiting for the ack
+		state := "pending"
+		if msg.Raw.Sequence <= consumer.Delivered.Stream {
+			state = "delivered"
+		}
+		rows = append(rows, []string{
+			strconv.FormatUint(msg.Raw.Sequence, 10),
+			msg.Task.KVId,
+			msg.Task.Tool,
+			msg.Task.Owner,
+			state,
+			msg.Raw.Time.UTC().Format(time.RFC3339),
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	printTable([]string{"SEQ", "TASK", "TOOL", "OWNER", "STATE", "PUBLISHED"}, rows)
+	return nil
+}
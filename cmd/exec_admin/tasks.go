@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"flag"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"os"
+	"sort"
+	"strconv"
+)
+
+func (a *admin) tasks(args []string) error {
+	fs := flag.NewFlagSet("tasks", flag.ContinueOnError)
+	status := fs.String("status", "", "Only tasks with this status: enqueued, processing, finished, cancelled or expired")
+	owner := fs.String("owner", "", "Only tasks of this principal")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := a.resultKvb.Keys(context.Background())
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	var rows [][]string
+	for _, key := range keys {
+		if !cmd.IsTaskKey(key) {
+			continue
+		}
+		entry, err := a.resultKvb.Get(key, context.Background())
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue // Deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		result := entry.Value()
+		if *status != "" && result.Status.ToString() != *status || *owner != "" && result.Owner != *owner {
+			continue
+		}
+		rows = append(rows, []string{key, result.Status.ToString(), result.Owner, result.ToolResultId, strconv.FormatUint(entry.Revision(), 10)})
+	}
+	printTable([]string{"ID", "STATUS", "OWNER", "RESULT-ID", "REVISION"}, rows)
+	return nil
+}
+
+func (a *admin) show(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a task id")
+	}
+	id := args[0]
+	entry, err := a.resultKvb.Get(id, context.Background())
+	if err != nil {
+		return err
+	}
+	result := entry.Value()
+	printTable([]string{"ID", "STATUS", "OWNER", "RESULT-ID", "REVISION"}, [][]string{
+		{id, result.Status.ToString(), result.Owner, result.ToolResultId, strconv.FormatUint(entry.Revision(), 10)},
+	})
+
+	queued, err := a.findQueued([]string{id})
+	if err != nil {
+		return err
+	}
+	if msg, ok := queued[id]; ok {
+		fmt.Printf("\nQueued message #%d:\n", msg.Raw.Sequence)
+		if err = printJson(msg.Task); err != nil {
+			return err
+		}
+	}
+
+	if result.ToolResultId == "" {
+		return nil
+	}
+	toolResult, err := common.GetTypedObject[cmd.ToolResult](a.osb, result.ToolResultId, a.serializers.ToolResult, context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nTool result:")
+	if err = printJson(toolResult); err != nil {
+		return err
+	}
+	var rows [][]string
+	for i, name := range toolResult.OutputFiles {
+		if name == "" {
+			rows = append(rows, []string{strconv.Itoa(i), "", "not created"})
+			continue
+		}
+		info, err := a.osb.GetInfo(name, context.Background())
+		if err != nil {
+			rows = append(rows, []string{strconv.Itoa(i), name, err.Error()})
+			continue
+		}
+		rows = append(rows, []string{strconv.Itoa(i), name, strconv.FormatUint(info.Size, 10)})
+	}
+	if len(rows) != 0 {
+		fmt.Println()
+		printTable([]string{"OUTPUT", "ARTIFACT", "SIZE"}, rows)
+	}
+	return nil
+}
+
+// history lists every status the task went through, as far as the bucket keeps it
+func (a *admin) history(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a task id")
+	}
+	entries, err := a.resultKvb.History(args[0], context.Background())
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, entry := range entries {
+		revision := strconv.FormatUint(entry.Revision(), 10)
+		switch entry.Operation() {
+		case common.KeyValueDelete:
+			rows = append(rows, []string{revision, "deleted", "", ""})
+		case common.KeyValuePurge:
+			rows = append(rows, []string{revision, "purged", "", ""})
+		default:
+			result := entry.Value()
+			rows = append(rows, []string{revision, result.Status.ToString(), result.Owner, result.ToolResultId})
+		}
+	}
+	printTable([]string{"REVISION", "STATUS", "OWNER", "RESULT-ID"}, rows)
+	return nil
+}
+
+func printJson(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false) // Placeholders in arguments are unreadable otherwise
+	return encoder.Encode(value)
+}
+
+func (a *admin) purge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	artifacts := fs.Bool("artifacts", false, "Also delete the tool result and the output files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ids := fs.Args()
+	if len(ids) == 0 {
+		return errors.New("expected task ids")
+	}
+	queued, err := a.findQueued(ids)
+	if err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, id := range ids {
+		if err := a.purgeTask(id, queued[id], *artifacts); err != nil {
+			common.Fprintfln(os.Stderr, "Failed to purge %s: %v", id, err)
+			failed = true
+			continue
+		}
+		common.Printfln("Purged %s", id)
+	}
+	if failed {
+		return errors.New("some tasks weren't purged")
+	}
+	return nil
+}
+
+// purgeTask cancels the task first, so a worker processing it kills the tool
+func (a *admin) purgeTask(id string, msg *cmd.QueuedTask, artifacts bool) error {
+	result, _, err := a.resultKvb.CAS(
+		id,
+		func(result *cmd.RunResult) (bool, error) {
+			return result.Status.IsFinal(), nil
+		},
+		func(result *cmd.RunResult) error {
+			result.Status = cmd.Cancelled
+			return nil
+		},
+		context.Background(),
+	)
+	if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+		return err
+	}
+	if msg != nil {
+		err = a.js.DeleteMsg(a.config.ConsumerConfig.StreamName, msg.Raw.Sequence)
+		if err != nil && !errors.Is(err, nats.ErrMsgNotFound) {
+			return err
+		}
+	}
+	if artifacts && result != nil && result.ToolResultId != "" {
+		toolResult, err := common.GetTypedObject[cmd.ToolResult](a.osb, result.ToolResultId, a.serializers.ToolResult, context.Background())
+		if err != nil && !errors.Is(err, common.ErrObjectNotFound) {
+			return err
+		}
+		var names []string
+		if toolResult != nil {
+			names = append(names, toolResult.OutputFiles...)
+		}
+		for _, name := range append(names, result.ToolResultId) {
+			if name == "" {
+				continue
+			}
+			if err := a.osb.Delete(name, context.Background()); err != nil && !errors.Is(err, common.ErrObjectNotFound) {
+				return err
+			}
+		}
+	}
+	return a.resultKvb.Purge(id, 0, context.Background())
+}
+
+// requeue publishes a copy of the queued message and deletes the original, which resets its delivery count
+func (a *admin) requeue(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected task ids")
+	}
+	queued, err := a.findQueued(args)
+	if err != nil {
+		return err
+	}
+
+	var failed bool
+	for _, id := range args {
+		msg, ok := queued[id]
+		if !ok {
+			common.Fprintfln(os.Stderr, "Task %s has no queued message, it was already acked", id)
+			failed = true
+			continue
+		}
+		if err := a.requeueTask(msg); err != nil {
+			common.Fprintfln(os.Stderr, "Failed to requeue %s: %v", id, err)
+			failed = true
+			continue
+		}
+		common.Printfln("Requeued %s", id)
+	}
+	if failed {
+		return errors.New("some tasks weren't requeued")
+	}
+	return nil
+}
+
+func (a *admin) requeueTask(msg *cmd.QueuedTask) error {
+	_, _, err := a.resultKvb.CAS(
+		msg.Task.KVId,
+		func(result *cmd.RunResult) (bool, error) {
+			if result.Status == cmd.Cancelled {
+				return false, errors.New("task is cancelled")
+			}
+			return false, nil
+		},
+		func(result *cmd.RunResult) error {
+			result.Status = cmd.Enqueued
+			return nil
+		},
+		context.Background(),
+	)
+	if err != nil {
+		return err
+	}
+	// Published before the original is deleted, so the task can't get lost. The msg id is dropped,
+	// the copy would be deduplicated otherwise
+	header := make(nats.Header, len(msg.Raw.Header))
+	for key, values := range msg.Raw.Header {
+		if key != nats.MsgIdHdr {
+			header[key] = values
+		}
+	}
+	copied := &nats.Msg{Subject: msg.Raw.Subject, Header: header, Data: msg.Raw.Data}
+	if _, err = nats2.RobustPublishMsg(context.Background(), a.retryPolicy, a.js, copied); err != nil {
+		return err
+	}
+	err = a.js.DeleteMsg(a.config.ConsumerConfig.StreamName, msg.Raw.Sequence)
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return nil // Acked meanwhile, the copy will be processed again
+	}
+	return err
+}
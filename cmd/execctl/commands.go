@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"exec/client"
+	"exec/cmd"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// languages maps the values of --lang to the extensions of their sources, the api only compiles c++ for now
+var languages = map[string][]string{
+	"cpp": {".cpp", ".cc", ".cxx"},
+}
+
+func detectLanguage(path string) string {
+	ext := filepath.Ext(path)
+	for lang, extensions := range languages {
+		for _, e := range extensions {
+			if e == ext {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+type compilation struct {
+	Id       string  `json:"id"`
+	Status   string  `json:"status"`
+	Verdict  verdict `json:"verdict,omitempty"`
+	BinaryId string  `json:"binary-id,omitempty"`
+	LogId    string  `json:"log-id,omitempty"`
+	Stats    string  `json:"stats,omitempty"`
+}
+
+func (c *compilation) row() []string {
+	return []string{c.Id, c.Status, string(c.Verdict), c.BinaryId, c.LogId}
+}
+
+var compilationHeaders = []string{"ID", "STATUS", "VERDICT", "BINARY-ID", "LOG-ID"}
+
+func compile(ctx context.Context, c *client.Client, path string, lang string, wait bool) (*compilation, error) {
+	if lang == "" {
+		lang = detectLanguage(path)
+	}
+	if _, ok := languages[lang]; !ok {
+		return nil, fmt.Errorf("unsupported language %q of %s", lang, path)
+	}
+	source, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	// The api tells the language by the extension
+	submission, err := c.Compile(ctx, source, "main"+languages[lang][0], "")
+	if err != nil {
+		return nil, err
+	}
+	// Finished right away on compile cache hits
+	result := &compilation{
+		Id:       submission.Id,
+		Status:   submission.Status,
+		BinaryId: submission.BinaryId,
+		LogId:    submission.LogId,
+		Stats:    submission.Stats,
+	}
+	if !wait {
+		return result, nil
+	}
+
+	task, err := c.WaitForResult(ctx, submission.Id)
+	if err != nil {
+		return nil, err
+	}
+	result.Status = task.Status
+	result.Stats = task.ToolOutput
+	result.Verdict = compilationVerdict(task)
+	if len(task.OutputIds) == 2 {
+		result.BinaryId = task.OutputIds[0]
+		result.LogId = task.OutputIds[1]
+	}
+	return result, nil
+}
+
+func submitCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("submit", "<source-file>")
+	lang := fs.String("lang", "", "Language of the source, detected from the extension if empty")
+	wait := fs.Bool("wait", false, "Wait for the compilation to finish")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	result, err := compile(ctx, g.client(), positional[0], *lang, *wait)
+	if err != nil {
+		return err
+	}
+	if err = g.print(result, compilationHeaders, [][]string{result.row()}); err != nil {
+		return err
+	}
+	if result.Verdict != "" {
+		return errFailedVerdict
+	}
+	return nil
+}
+
+type runOutcome struct {
+	Test     string  `json:"test,omitempty"`
+	Id       string  `json:"id"`
+	Status   string  `json:"status"`
+	Verdict  verdict `json:"verdict,omitempty"`
+	StdoutId string  `json:"stdout-id,omitempty"`
+	StderrId string  `json:"stderr-id,omitempty"`
+	Stats    string  `json:"stats,omitempty"`
+	Error    string  `json:"error,omitempty"` // Only set by judge, which carries on with other tests
+}
+
+func (r *runOutcome) row() []string {
+	return []string{r.Id, r.Status, string(r.Verdict), r.StdoutId, r.StderrId}
+}
+
+var runHeaders = []string{"ID", "STATUS", "VERDICT", "STDOUT-ID", "STDERR-ID"}
+
+// runTest waits for the run when the output is checked, inputPath and expectPath may be empty
+func runTest(ctx context.Context, c *client.Client, binaryId string, inputPath string, expectPath string, wait bool) (*runOutcome, error) {
+	var expected []byte
+	if expectPath != "" {
+		var err error
+		if expected, err = os.ReadFile(expectPath); err != nil {
+			return nil, err
+		}
+		wait = true
+	}
+	var run *cmd.ApiRun
+	if inputPath != "" {
+		input, err := os.Open(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		run, err = c.RunWithInput(ctx, binaryId, input, "")
+		input.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if run, err = c.Run(ctx, binaryId, ""); err != nil {
+			return nil, err
+		}
+	}
+	result := &runOutcome{Id: run.Id, Status: run.Status}
+	if !wait {
+		return result, nil
+	}
+
+	task, err := c.WaitForResult(ctx, run.Id)
+	if err != nil {
+		return nil, err
+	}
+	result.Status = task.Status
+	result.Stats = task.ToolOutput
+	if len(task.OutputIds) == 2 {
+		result.StdoutId = task.OutputIds[0]
+		result.StderrId = task.OutputIds[1]
+	}
+	if expectPath == "" {
+		return result, nil
+	}
+	var stdout bytes.Buffer
+	if result.StdoutId != "" {
+		if err = c.DownloadArtifact(ctx, result.StdoutId, &stdout); err != nil {
+			return nil, err
+		}
+	}
+	result.Verdict = runVerdict(task, stdout.Bytes(), expected)
+	return result, nil
+}
+
+func runCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("run", "<binary-id>")
+	input := fs.String("input", "", "File fed to stdin")
+	expect := fs.String("expect", "", "File with the expected stdout, implies -wait")
+	wait := fs.Bool("wait", false, "Wait for the run to finish")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	result, err := runTest(ctx, g.client(), positional[0], *input, *expect, *wait)
+	if err != nil {
+		return err
+	}
+	if err = g.print(result, runHeaders, [][]string{result.row()}); err != nil {
+		return err
+	}
+	if result.Verdict != "" && result.Verdict != verdictOk {
+		return errFailedVerdict
+	}
+	return nil
+}
+
+func statusCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("status", "<id>")
+	wait := fs.Bool("wait", false, "Wait for the task to finish")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	c := g.client()
+	var task *cmd.ApiTask
+	if *wait {
+		task, err = c.WaitForResult(ctx, positional[0])
+	} else {
+		task, err = c.Status(ctx, positional[0])
+	}
+	if err != nil {
+		return err
+	}
+	return g.print(task, []string{"ID", "STATUS", "OUTPUT-IDS"}, [][]string{
+		{task.Id, task.Status, strings.Join(task.OutputIds, ",")},
+	})
+}
+
+func downloadCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("download", "<artifact-id>")
+	output := fs.String("o", "-", "Output file, - for stdout")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	if *output == "-" {
+		return g.client().DownloadArtifact(ctx, positional[0], os.Stdout)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = g.client().DownloadArtifact(ctx, positional[0], file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(*output)
+	}
+	return err
+}
+
+func cancelCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("cancel", "<id>")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	task, err := g.client().Cancel(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return g.print(task, []string{"ID", "STATUS"}, [][]string{{task.Id, task.Status}})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const verdictError verdict = "ERR"
+
+type judgeReport struct {
+	Compilation *compilation  `json:"compilation"`
+	Tests       []*runOutcome `json:"tests"`
+	Passed      int           `json:"passed"`
+}
+
+type testCase struct {
+	name       string
+	inputPath  string
+	expectPath string
+}
+
+// findSource returns the only source file of a supported language in dir
+func findSource(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var sources []string
+	for _, entry := range entries {
+		if !entry.IsDir() && detectLanguage(entry.Name()) != "" {
+			sources = append(sources, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(sources) != 1 {
+		return "", fmt.Errorf("expected exactly one source file in %s but found %d, use -source", dir, len(sources))
+	}
+	return sources[0], nil
+}
+
+// findTests pairs every N.in in dir and dir/tests with N.out or N.ans
+func findTests(dir string) ([]testCase, error) {
+	var tests []testCase
+	for _, testsDir := range []string{dir, filepath.Join(dir, "tests")} {
+		inputs, err := filepath.Glob(filepath.Join(testsDir, "*.in"))
+		if err != nil {
+			return nil, err
+		}
+		for _, input := range inputs {
+			base := strings.TrimSuffix(input, ".in")
+			test := testCase{name: strings.TrimPrefix(base, dir+string(filepath.Separator)), inputPath: input}
+			for _, ext := range []string{".out", ".ans"} {
+				if _, err := os.Stat(base + ext); err == nil {
+					test.expectPath = base + ext
+					break
+				}
+			}
+			if test.expectPath == "" {
+				return nil, fmt.Errorf("no expected output for %s", input)
+			}
+			tests = append(tests, test)
+		}
+	}
+	if len(tests) == 0 {
+		return nil, errors.New("no tests found in " + dir)
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		return naturalLess(tests[i].name, tests[j].name)
+	})
+	return tests, nil
+}
+
+// naturalLess compares runs of digits by value, so "test2" goes before "test10" and "a10" before "b1"
+func naturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			numA, restA := digitRun(a)
+			numB, restB := digitRun(b)
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digitRun splits the leading digits of s off, without leading zeros
+func digitRun(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return strings.TrimLeft(s[:i], "0"), s[i:]
+}
+
+func judgeCommand(ctx context.Context, args []string) error {
+	fs, g := newFlagSet("judge", "<dir>")
+	source := fs.String("source", "", "Source file, the only source in dir if empty")
+	lang := fs.String("lang", "", "Language of the source, detected from the extension if empty")
+	parallel := fs.Int("j", 4, "Number of tests run at once")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *parallel < 1 {
+		return errors.New("-j must be positive")
+	}
+	dir := filepath.Clean(positional[0])
+	ctx, cancel := g.withTimeout(ctx)
+	defer cancel()
+
+	if *source == "" {
+		if *source, err = findSource(dir); err != nil {
+			return err
+		}
+	}
+	tests, err := findTests(dir)
+	if err != nil {
+		return err
+	}
+
+	c := g.client()
+	report := &judgeReport{}
+	report.Compilation, err = compile(ctx, c, *source, *lang, true)
+	if err != nil {
+		return err
+	}
+	if report.Compilation.Verdict == "" {
+		report.Tests = make([]*runOutcome, len(tests))
+		semaphore := make(chan struct{}, *parallel)
+		var wg common.WorkGroup
+		for i, test := range tests {
+			i, test := i, test
+			semaphore <- struct{}{}
+			wg.Spawn(func() {
+				defer func() { <-semaphore }()
+				outcome, err := runTest(ctx, c, report.Compilation.BinaryId, test.inputPath, test.expectPath, true)
+				if err != nil {
+					outcome = &runOutcome{Verdict: verdictError, Error: err.Error()}
+				}
+				outcome.Test = test.name
+				report.Tests[i] = outcome
+			})
+		}
+		wg.Wait()
+	}
+
+	var rows [][]string
+	if report.Compilation.Verdict != "" {
+		rows = append(rows, []string{filepath.Base(*source), string(report.Compilation.Verdict), report.Compilation.Id, ""})
+	}
+	for _, outcome := range report.Tests {
+		if outcome.Verdict == verdictOk {
+			report.Passed++
+		}
+		rows = append(rows, []string{outcome.Test, string(outcome.Verdict), outcome.Id, outcome.Error})
+	}
+	if err = g.print(report, []string{"TEST", "VERDICT", "ID", "ERROR"}, rows); err != nil {
+		return err
+	}
+	if !g.json {
+		fmt.Printf("Passed %d/%d\n", report.Passed, len(tests))
+	}
+	if report.Passed != len(tests) {
+		return errFailedVerdict
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"test10", "b1", "test2", "a10", "test", "test1a", "test01b", "a9"}
+	sort.Slice(names, func(i, j int) bool {
+		return naturalLess(names[i], names[j])
+	})
+	expected := []string{"a9", "a10", "b1", "test", "test1a", "test01b", "test2", "test10"}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("sorted as %v instead of %v", names, expected)
+		}
+	}
+}
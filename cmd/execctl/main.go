@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"exec/client"
+	"exec/common"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: execctl <command> [arguments] [flags]
+
+Commands:
+  submit <source-file>    Compile a source file
+  run <binary-id>         Run a compiled binary, optionally checking its output
+  status <id>             Show the status of any task
+  download <artifact-id>  Download an artifact
+  cancel <id>             Cancel a task
+  judge <dir>             Compile the source in dir and run it against every test in dir
+
+Run "execctl <command> -help" for the flags of a command`
+
+// Make execctl exit without printing anything else
+var (
+	errFailedVerdict = errors.New("some verdicts are not OK")
+	errUsage         = errors.New("bad usage")
+)
+
+type command func(ctx context.Context, args []string) error
+
+func main() {
+	commands := map[string]command{
+		"submit":   submitCommand,
+		"run":      runCommand,
+		"status":   statusCommand,
+		"download": downloadCommand,
+		"cancel":   cancelCommand,
+		"judge":    judgeCommand,
+	}
+	if len(os.Args) < 2 {
+		common.Printfln(usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-help" && os.Args[1] != "--help" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "execctl: unknown command %q\n", os.Args[1])
+		}
+		common.Printfln(usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	err := cmd(ctx, os.Args[2:])
+	stop()
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errFailedVerdict):
+		os.Exit(1)
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "execctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// globalFlags are accepted by every command
+type globalFlags struct {
+	url     string
+	apiKey  string
+	token   string
+	json    bool
+	timeout time.Duration
+}
+
+func newFlagSet(name string, positional string) (*flag.FlagSet, *globalFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: execctl %s %s [flags]\n", name, positional)
+		fs.PrintDefaults()
+	}
+	g := &globalFlags{}
+	fs.StringVar(&g.url, "url", envOr("EXEC_API_URL", "http://localhost:8000"), "Base url of the api, $EXEC_API_URL")
+	// Secrets aren't used as defaults, so they don't show up in the usage
+	fs.StringVar(&g.apiKey, "api-key", "", "Api key, $EXEC_API_KEY if empty")
+	fs.StringVar(&g.token, "token", "", "Bearer token used instead of the api key, $EXEC_API_TOKEN if empty")
+	fs.BoolVar(&g.json, "json", false, "Print json instead of a table")
+	fs.DurationVar(&g.timeout, "timeout", 0, "Give up after this long, 0 means never")
+	return fs, g
+}
+
+func envOr(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// parseArgs allows flags after positional arguments, e.g. "submit main.cpp --wait"
+func parseArgs(fs *flag.FlagSet, args []string, expectedPositional int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != expectedPositional {
+		fmt.Fprintf(fs.Output(), "Expected %d arguments but got %d\n", expectedPositional, len(positional))
+		fs.Usage()
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+func (g *globalFlags) client() *client.Client {
+	if g.apiKey == "" {
+		g.apiKey = os.Getenv("EXEC_API_KEY")
+	}
+	if g.token == "" {
+		g.token = os.Getenv("EXEC_API_TOKEN")
+	}
+	var options []client.Option
+	if g.apiKey != "" {
+		options = append(options, client.WithApiKey(g.apiKey))
+	}
+	if g.token != "" {
+		options = append(options, client.WithBearerToken(g.token))
+	}
+	return client.New(g.url, options...)
+}
+
+func (g *globalFlags) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, g.timeout)
+}
+
+// print writes value as json, or as a table of its headers and rows
+func (g *globalFlags) print(value any, headers []string, rows [][]string) error {
+	if g.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
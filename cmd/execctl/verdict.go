@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"exec/client"
+	"exec/cmd"
+)
+
+type verdict string
+
+const (
+	verdictOk               verdict = "OK"
+	verdictWrongAnswer      verdict = "WA"
+	verdictCompilationError verdict = "CE"
+	verdictNoOutput         verdict = "NO"
+	verdictCancelled        verdict = "CANCELLED"
+	verdictExpired          verdict = "EXPIRED"
+)
+
+// compilationVerdict is empty unless the compilation failed
+func compilationVerdict(task *cmd.ApiTask) verdict {
+	switch {
+	case task.Status == client.StatusCancelled:
+		return verdictCancelled
+	case task.Status == client.StatusExpired:
+		return verdictExpired
+	case len(task.OutputIds) == 0 || task.OutputIds[0] == "":
+		return verdictCompilationError
+	}
+	return ""
+}
+
+func runVerdict(task *cmd.ApiTask, stdout []byte, expected []byte) verdict {
+	switch {
+	case task.Status == client.StatusCancelled:
+		return verdictCancelled
+	case task.Status == client.StatusExpired:
+		return verdictExpired
+	case len(task.OutputIds) == 0 || task.OutputIds[0] == "":
+		return verdictNoOutput
+	case !bytes.Equal(normalizeOutput(stdout), normalizeOutput(expected)):
+		return verdictWrongAnswer
+	}
+	return verdictOk
+}
+
+// normalizeOutput ignores trailing whitespace on every line and trailing empty lines
+func normalizeOutput(output []byte) []byte {
+	lines := bytes.Split(bytes.ReplaceAll(output, []byte("\r\n"), []byte("\n")), []byte("\n"))
+	for i := range lines {
+		lines[i] = bytes.TrimRight(lines[i], " \t")
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"github.com/nats-io/nats.go"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// rateLimitIdleTime after which a token bucket is full again for any sane config, so it can be dropped
+	rateLimitIdleTime = 24 * time.Hour
+
+	// orphanRefsAge after which references of a content addressed object that was never uploaded are dropped
+	orphanRefsAge = time.Hour
+
+	// outputUploadTime bounds how much earlier than their tool result output files are uploaded
+	outputUploadTime = time.Hour
+)
+
+type collector struct {
+	js          nats.JetStreamContext
+	stream      string
+	kv          nats.KeyValue
+	policy      *nats2.RetryPolicy
+	resultKvb   common.KeyValueBucket[cmd.RunResult]
+	osb         common.ObjectStore
+	contents    *nats2.ContentStore
+	retention   *cmd.RetentionConfig
+	serializers *cmd.Serializers
+	logger      *log.Logger
+}
+
+// collect keeps references consistent: tasks referencing an expired object are marked expired before it's deleted,
+// and inputs of queued tasks are kept until the task is processed
+func (c *collector) collect(ctx context.Context, now time.Time) error {
+	expired, results, err := c.expiredObjects(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(expired) != 0 {
+		if err = c.expireTasks(ctx, now, expired, results); err != nil {
+			return err
+		}
+	}
+	var deleted int
+	for name, retention := range expired {
+		var err error
+		if nats2.IsContentAddressed(name) {
+			retention := retention
+			err = c.contents.Delete(ctx, name, func(refs *nats2.ObjectRefs) bool {
+				return refs.LastReferenced.Add(retention).Before(now)
+			})
+			if errors.Is(err, nats2.ErrObjectReferenced) {
+				continue
+			}
+		} else {
+			err = c.osb.Delete(name, ctx)
+		}
+		if err != nil && !errors.Is(err, common.ErrObjectNotFound) {
+			common.HandleErrLog(err, c.logger)
+			continue
+		}
+		deleted++
+	}
+	c.logger.Printf("Deleted %d expired objects", deleted)
+	return c.collectEntries(ctx, now)
+}
+
+// expiredObjects maps names of expired objects to their retention, and names of all tool results to their creation
+func (c *collector) expiredObjects(ctx context.Context, now time.Time) (map[string]time.Duration, map[string]time.Time, error) {
+	infos, err := c.osb.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	expired := make(map[string]time.Duration)
+	results := make(map[string]time.Time)
+	for _, info := range infos {
+		class := cmd.ObjectClass(info)
+		if class == cmd.ResultArtifact {
+			results[info.Name] = cmd.ObjectCreated(info)
+		}
+		retention := c.retention.Of(class)
+		if retention == 0 {
+			continue
+		}
+		// Uploads of content addressed objects are skipped, but still extend their lifetime
+		lastUsed := info.ModTime
+		if nats2.IsContentAddressed(info.Name) {
+			refs, err := c.contents.Refs(ctx, info.Name)
+			if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+				return nil, nil, err
+			}
+			if refs != nil && refs.LastReferenced.After(lastUsed) {
+				lastUsed = refs.LastReferenced
+			}
+		}
+		if lastUsed.Add(retention).Before(now) {
+			expired[info.Name] = retention
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil, nil
+	}
+
+	err = cmd.ScanQueue(c.js, c.stream, c.serializers.Task, func(msg *cmd.QueuedTask) bool {
+		for _, input := range msg.Task.InputFiles {
+			delete(expired, input.ObjectStoreId)
+		}
+		return true
+	})
+	return expired, results, err
+}
+
+// expireTasks marks finished tasks whose tool result or output files are about to be deleted.
+// Only tool results old enough to refer to expired output files are downloaded
+func (c *collector) expireTasks(ctx context.Context, now time.Time, expired map[string]time.Duration, results map[string]time.Time) error {
+	keys, err := nats2.RobustKVKeys(ctx, c.policy, c.kv)
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Output files expire after their shortest retention at the earliest, counted from their upload
+	var minRetention time.Duration
+	for name, retention := range expired {
+		if _, ok := results[name]; !ok && (minRetention == 0 || retention < minRetention) {
+			minRetention = retention
+		}
+	}
+	referencing := make(map[string]bool) // Tool result ids checked already, cache hits share them
+	var marked int
+	for _, key := range keys {
+		if !cmd.IsTaskKey(key) {
+			continue
+		}
+		entry, err := c.resultKvb.Get(key, ctx)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		result := entry.Value()
+		if result.Status != cmd.Finished {
+			continue
+		}
+		references, ok := referencing[result.ToolResultId]
+		if !ok {
+			references, err = c.referencesExpired(ctx, now, result.ToolResultId, expired, results, minRetention)
+			if err != nil {
+				return err
+			}
+			referencing[result.ToolResultId] = references
+		}
+		if !references {
+			continue
+		}
+		_, _, err = c.resultKvb.CAS(
+			key,
+			func(current *cmd.RunResult) (bool, error) {
+				return current.Status != cmd.Finished || current.ToolResultId != result.ToolResultId, nil
+			},
+			func(current *cmd.RunResult) error {
+				current.Status = cmd.Expired
+				return nil
+			},
+			ctx,
+		)
+		if err != nil && !errors.Is(err, nats.ErrKeyNotFound) {
+			return err
+		}
+		marked++
+	}
+	c.logger.Printf("Marked %d tasks expired", marked)
+	return nil
+}
+
+// referencesExpired downloads the tool result only if it's old enough for its output files to be expired,
+// minRetention is the shortest retention of the expired output files, zero if there are none
+func (c *collector) referencesExpired(
+	ctx context.Context,
+	now time.Time,
+	toolResultId string,
+	expired map[string]time.Duration,
+	results map[string]time.Time,
+	minRetention time.Duration,
+) (bool, error) {
+	if _, ok := expired[toolResultId]; ok {
+		return true, nil
+	}
+	if minRetention == 0 {
+		return false, nil
+	}
+	// Results stored after the listing aren't known, they're downloaded like the candidates
+	if created, ok := results[toolResultId]; ok && !created.Add(minRetention).Before(now.Add(outputUploadTime)) {
+		return false, nil
+	}
+	toolResult, err := common.GetTypedObject[cmd.ToolResult](c.osb, toolResultId, c.serializers.ToolResult, ctx)
+	if errors.Is(err, common.ErrObjectNotFound) {
+		return true, nil // Deleted by hand, the task is unusable anyway
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, name := range toolResult.OutputFiles {
+		if _, ok := expired[name]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// collectEntries deletes old final tasks, quotas of past days, idle rate limits, orphaned references
+// and expired idempotency keys
+func (c *collector) collectEntries(ctx context.Context, now time.Time) error {
+	keys, err := nats2.RobustKVKeys(ctx, c.policy, c.kv)
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	year, month, day := now.UTC().Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	var deleted int
+	for _, key := range keys {
+		entry, err := nats2.RobustGetKVEntry(ctx, c.policy, c.kv, key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !c.isEntryExpired(ctx, entry, now, today) {
+			continue
+		}
+		// Fails if the entry was updated meanwhile
+		err = nats2.RobustPurgeKVEntry(ctx, c.policy, c.kv, key, nats.LastRevision(entry.Revision()))
+		if err != nil {
+			common.HandleErrLog(err, c.logger)
+			continue
+		}
+		deleted++
+	}
+	c.logger.Printf("Deleted %d expired key value entries", deleted)
+	return nats2.RobustPurgeKVDeletes(ctx, c.policy, c.kv)
+}
+
+func (c *collector) isEntryExpired(ctx context.Context, entry nats.KeyValueEntry, now time.Time, today time.Time) bool {
+	key := entry.Key()
+	if day, ok := cmd.QuotaKeyDay(key); ok {
+		return day.Before(today)
+	}
+	if strings.HasPrefix(key, cmd.RateLimitKeyPrefix) {
+		return entry.Created().Add(rateLimitIdleTime).Before(now)
+	}
+	if strings.HasPrefix(key, cmd.IdempotencyKeyPrefix) {
+		record, err := (&common.JsonSerializer[cmd.IdempotencyRecord]{}).Deserialize(entry.Value())
+		if err != nil {
+			common.HandleErrLog(err, c.logger)
+			return false
+		}
+		return record.Expires.Before(now)
+	}
+	if name, ok := strings.CutPrefix(key, nats2.RefsKeyPrefix); ok {
+		if !entry.Created().Add(orphanRefsAge).Before(now) {
+			return false
+		}
+		_, err := c.osb.GetInfo(name, ctx)
+		return errors.Is(err, common.ErrObjectNotFound)
+	}
+	retention := c.retention.Task.Duration
+	if !cmd.IsTaskKey(key) || retention == 0 || !entry.Created().Add(retention).Before(now) {
+		return false
+	}
+	result, err := c.serializers.Result.Deserialize(entry.Value())
+	if err != nil {
+		common.HandleErrLog(err, c.logger)
+		return false
+	}
+	return result.Status.IsFinal()
+}
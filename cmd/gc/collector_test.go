@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"exec/nats/natstest"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingObjectStore counts downloads of whole objects
+type countingObjectStore struct {
+	common.ObjectStore
+	gets atomic.Int32
+}
+
+func (s *countingObjectStore) Get(name string, ctx context.Context) ([]byte, error) {
+	s.gets.Add(1)
+	return s.ObjectStore.Get(name, ctx)
+}
+
+type testCollector struct {
+	*collector
+	t   *testing.T
+	osb *countingObjectStore
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	if testing.Short() {
+		t.Skip("starts a nats server")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	config := &cmd.WorkerConfig{
+		ConsumerConfig:          cmd.ConsumerConfig{StreamName: "tasks", Name: "workers", Replicas: 1},
+		ObjectStoreBucketConfig: cmd.ObjectStoreBucketConfig{Name: "artifacts", Replicas: 1},
+		KeyValueBucketConfig:    cmd.KeyValueBucketConfig{Name: "db", Replicas: 1},
+	}
+	if err := cmd.SetupJetStream(js, config, nil); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.KeyValue("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := config.RetryConfig.Policy(nil)
+	nosb, err := cmd.OpenObjectStore(js, &config.ObjectStoreConfig, &config.ObjectStoreBucketConfig, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializers, err := cmd.NewSerializers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	osb := &countingObjectStore{ObjectStore: nosb}
+	return &testCollector{
+		collector: &collector{
+			js:        js,
+			stream:    "tasks",
+			kv:        kv,
+			policy:    policy,
+			resultKvb: nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, serializers.Result, policy),
+			osb:       osb,
+			contents:  nats2.NewContentStore(osb, kv, policy),
+			retention: &cmd.RetentionConfig{
+				Source: cmd.Duration{Duration: time.Hour},
+				Output: cmd.Duration{Duration: 24 * time.Hour},
+				Result: cmd.Duration{Duration: 72 * time.Hour},
+			},
+			serializers: serializers,
+			logger:      log.New(io.Discard, "", 0),
+		},
+		t:   t,
+		osb: osb,
+	}
+}
+
+func (c *testCollector) put(class cmd.ArtifactClass) string {
+	meta := cmd.ArtifactMeta(common.GetRandomId(), "", class)
+	if _, err := c.osb.Put(meta, strings.NewReader("content"), context.Background()); err != nil {
+		c.t.Fatal(err)
+	}
+	return meta.Name
+}
+
+// finish stores the tool result of a finished task created at the given time
+func (c *testCollector) finish(task string, created time.Time, outputFiles ...string) string {
+	meta := cmd.ArtifactMeta(common.GetRandomId(), "", cmd.ResultArtifact)
+	meta.Headers.Set(cmd.ObjectCreatedHeader, created.UTC().Format(time.RFC3339Nano))
+	_, err := common.PutTypedObject(c.osb, meta, &cmd.ToolResult{OutputFiles: outputFiles}, c.serializers.ToolResult, context.Background())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, err = c.resultKvb.Create(task, &cmd.RunResult{Status: cmd.Finished, ToolResultId: meta.Name}, context.Background())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return meta.Name
+}
+
+func (c *testCollector) exists(name string) bool {
+	_, err := c.osb.GetInfo(name, context.Background())
+	if errors.Is(err, common.ErrObjectNotFound) {
+		return false
+	}
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return true
+}
+
+func (c *testCollector) status(task string) cmd.RunStatus {
+	entry, err := c.resultKvb.Get(task, context.Background())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return entry.Value().Status
+}
+
+func TestCollectorKeepsObjectsWithinRetention(t *testing.T) {
+	c := newTestCollector(t)
+	source := c.put(cmd.SourceArtifact)
+	output := c.put(cmd.OutputArtifact)
+	unclassified := c.put("")
+	result := c.finish("task", time.Now(), output)
+
+	if err := c.collect(context.Background(), time.Now().Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{source, output, unclassified, result} {
+		if !c.exists(name) {
+			t.Fatalf("%s was deleted within its retention", name)
+		}
+	}
+
+	if err := c.collect(context.Background(), time.Now().Add(100*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{source, output, result} {
+		if c.exists(name) {
+			t.Fatalf("%s outlived its retention", name)
+		}
+	}
+	if !c.exists(unclassified) {
+		t.Fatal("object without a class was deleted")
+	}
+}
+
+func TestCollectorExpiresTasksReferencingExpiredObjects(t *testing.T) {
+	c := newTestCollector(t)
+	now := time.Now()
+	expiredOutput := c.put(cmd.OutputArtifact)
+	referencing := c.finish("referencing", now, expiredOutput)
+	unreferencing := c.finish("unreferencing", now, "")
+	// Stored just before the collection, so its output files can't be expired and it isn't downloaded
+	recent := c.finish("recent", now.Add(47*time.Hour), "")
+
+	queuedSource := c.put(cmd.SourceArtifact)
+	publisher := nats2.NewPublisherWrapper[cmd.TaskMsg](c.js, c.serializers.Task, c.policy)
+	task := &cmd.TaskMsg{InputFiles: []cmd.InputFile{{ObjectStoreId: queuedSource}}, KVId: "queued"}
+	if err := publisher.PublishSync("tasks", task); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.collect(context.Background(), now.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if c.status("referencing") != cmd.Expired || c.exists(expiredOutput) {
+		t.Fatal("task referencing a deleted output file wasn't expired")
+	}
+	if c.status("unreferencing") != cmd.Finished || c.status("recent") != cmd.Finished {
+		t.Fatal("task referencing no expired object was expired")
+	}
+	for _, name := range []string{referencing, unreferencing, recent} {
+		if !c.exists(name) {
+			t.Fatalf("tool result %s was deleted within its retention", name)
+		}
+	}
+	if gets := c.osb.gets.Load(); gets != 2 {
+		t.Fatalf("downloaded %d tool results instead of the 2 old enough", gets)
+	}
+	if !c.exists(queuedSource) {
+		t.Fatal("input of a queued task was deleted")
+	}
+}
+
+func TestScanQueueSkipsAckedMessages(t *testing.T) {
+	c := newTestCollector(t)
+	publisher := nats2.NewPublisherWrapper[cmd.TaskMsg](c.js, c.serializers.Task, c.policy)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := publisher.PublishSync("tasks", &cmd.TaskMsg{KVId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.js.DeleteMsg("tasks", 2); err != nil {
+		t.Fatal(err)
+	}
+	var visited bytes.Buffer
+	err := cmd.ScanQueue(c.js, "tasks", c.serializers.Task, func(msg *cmd.QueuedTask) bool {
+		visited.WriteString(msg.Task.KVId)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited.String() != "ac" {
+		t.Fatalf("visited %q", visited.String())
+	}
+}
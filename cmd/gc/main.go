@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const defaultGcInterval = time.Hour
+
+func main() {
+	configPath := flag.String("config-file", "worker-config.json", "Path to the worker config file")
+	once := flag.Bool("once", false, "Collect once and exit instead of running every gc-interval")
+	help := flag.Bool("help", false, "Print help")
+	flag.Parse()
+
+	if *help {
+		common.Printfln("Usage: %s", os.Args[0])
+		flag.PrintDefaults()
+		return
+	}
+
+	env := cmd.ParseEnvironment(os.Environ())
+	var workerConfig cmd.WorkerConfig
+	common.HandlePanic(cmd.ParseConfigFileWithRespectToEnv(*configPath, env, &workerConfig))
+	serializers, err := cmd.NewSerializers(workerConfig.SerializationFormat)
+	common.HandlePanic(err)
+
+	nc, err := workerConfig.ConnectionConfig.Connect()
+	common.HandlePanic(err)
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	common.HandlePanic(err)
+
+	kv, err := js.KeyValue(workerConfig.KeyValueBucketConfig.Name)
+	common.HandlePanic(err)
+	logger := log.New(
+		os.Stderr,
+		"GC: ",
+		log.LstdFlags|log.LUTC|log.Lmsgprefix|log.Lmicroseconds,
+	)
+	retryPolicy := workerConfig.RetryConfig.Policy(logger)
+
+	osb, err := cmd.OpenObjectStore(js, &workerConfig.ObjectStoreConfig, &workerConfig.ObjectStoreBucketConfig, retryPolicy)
+	common.HandlePanic(err)
+
+	c := &collector{
+		js:          js,
+		stream:      workerConfig.ConsumerConfig.StreamName,
+		kv:          kv,
+		policy:      retryPolicy,
+		resultKvb:   nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, serializers.Result, retryPolicy),
+		osb:         osb,
+		contents:    nats2.NewContentStore(osb, kv, retryPolicy),
+		retention:   &workerConfig.RetentionConfig,
+		serializers: serializers,
+		logger:      logger,
+	}
+
+	interval := workerConfig.RetentionConfig.GcInterval.Duration
+	if interval == 0 {
+		interval = defaultGcInterval
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if *once {
+		common.HandlePanic(c.collect(ctx, time.Now()))
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		common.HandleErrLog(c.collect(ctx, time.Now()), logger)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
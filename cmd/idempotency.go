@@ -0,0 +1,24 @@
+package cmd
+
+import "time"
+
+// IdempotencyRecord remembers the task of a request with an Idempotency-Key header, and its response once it succeeded.
+// Stored in the key value bucket under IdempotencyKey
+type IdempotencyRecord struct {
+	Request     string    `json:"request"` // Method, path, query and hash of the body, the key can't be reused for other requests
+	TaskId      string    `json:"task-id"` // Reserved for the task of the request, retries publish it under the same msg id
+	StatusCode  int       `json:"status-code,omitempty"`
+	ContentType string    `json:"content-type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Completed once the response is stored, repeats then only get it replayed
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// IdempotencyKey is scoped to the principal, so clients can't replay responses of each other
+func IdempotencyKey(principal string, key string) string {
+	return IdempotencyKeyPrefix + HashKeyPart(principal) + "." + HashKeyPart(key)
+}
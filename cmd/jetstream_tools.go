@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"errors"
+	"exec/common"
 	"github.com/nats-io/nats.go"
 	"reflect"
 )
@@ -82,3 +84,128 @@ func CreateOrGetKeyValueStoreBucket(kvm nats.KeyValueManager, config *nats.KeyVa
 	}
 	return kvm.CreateKeyValue(config)
 }
+
+// Upsert applies alter to the current value of the key, missing keys are created
+// from the zero value with alter applied
+func Upsert[T any](kvb common.KeyValueBucket[T], key string, alter func(*T) error, ctx context.Context) (*T, error) {
+	for {
+		value, _, err := kvb.CAS(
+			key,
+			func(*T) (bool, error) {
+				return false, nil
+			},
+			alter,
+			ctx,
+		)
+		if !errors.Is(err, nats.ErrKeyNotFound) {
+			return value, err
+		}
+		var initial T
+		if err = alter(&initial); err != nil {
+			return nil, err
+		}
+		_, err = kvb.Create(key, &initial, ctx)
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &initial, nil
+	}
+}
+
+// SetupJetStream idempotently creates the streams, consumers and buckets of the configs,
+// api specific buckets are skipped if apiConfig is nil
+func SetupJetStream(js nats.JetStreamContext, workerConfig *WorkerConfig, apiConfig *ApiConfig) error {
+	consumerConfig := workerConfig.ConsumerConfig
+
+	_, err := SetStream(
+		js,
+		&nats.StreamConfig{
+			Name:        consumerConfig.StreamName,
+			Subjects:    []string{consumerConfig.StreamName, consumerConfig.StreamName + ".>"},
+			Retention:   nats.WorkQueuePolicy,
+			Replicas:    consumerConfig.Replicas,
+			AllowDirect: true, // For ScanQueue
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = SetConsumer(
+		js,
+		consumerConfig.StreamName,
+		&nats.ConsumerConfig{
+			Name:      consumerConfig.Name,
+			Durable:   consumerConfig.Name,
+			AckPolicy: nats.AckExplicitPolicy,
+			AckWait:   consumerConfig.AckWaitTime.Duration,
+			Replicas:  consumerConfig.Replicas,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	osBucketConfig := workerConfig.ObjectStoreBucketConfig
+	_, err = CreateOrGetObjectStoreBucket(
+		js,
+		&nats.ObjectStoreConfig{
+			Bucket:      osBucketConfig.Name,
+			Description: osBucketConfig.Description,
+			Replicas:    osBucketConfig.Replicas,
+		})
+	if err != nil {
+		return err
+	}
+
+	kvBucketConfig := workerConfig.KeyValueBucketConfig
+	_, err = CreateOrGetKeyValueStoreBucket(
+		js,
+		&nats.KeyValueConfig{
+			Bucket:      kvBucketConfig.Name,
+			Description: kvBucketConfig.Description,
+			Replicas:    kvBucketConfig.Replicas,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	compileCacheConfig := workerConfig.CompileCacheConfig
+	if compileCacheConfig.Enabled() {
+		_, err = CreateOrGetKeyValueStoreBucket(
+			js,
+			&nats.KeyValueConfig{
+				Bucket:      compileCacheConfig.KeyValueBucketConfig.Name,
+				Description: compileCacheConfig.KeyValueBucketConfig.Description,
+				Replicas:    compileCacheConfig.KeyValueBucketConfig.Replicas,
+				TTL:         compileCacheConfig.Ttl.Duration,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if apiConfig == nil {
+		return nil
+	}
+	apiKeysBucketConfig := apiConfig.AuthConfig.ApiKeysBucketConfig
+	if apiKeysBucketConfig.Name != "" {
+		_, err = CreateOrGetKeyValueStoreBucket(
+			js,
+			&nats.KeyValueConfig{
+				Bucket:      apiKeysBucketConfig.Name,
+				Description: apiKeysBucketConfig.Description,
+				Replicas:    apiKeysBucketConfig.Replicas,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
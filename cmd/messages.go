@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -10,18 +12,28 @@ import (
 var inheritedEnvPrefixes = [...]string{"PATH="}
 
 type InputFile struct {
-	ObjectStoreId string `json:"object-store-id"`
-	Extension     string `json:"extension,omitempty"`
+	ObjectStoreId string `json:"object-store-id" proto:"1"`
+	Extension     string `json:"extension,omitempty" proto:"2"`
 }
 
 type TaskMsg struct {
-	InputFiles           []InputFile `json:"input-files"`
-	OutputFileExtensions []string    `json:"output-file-extensions"` // List of output files extensions
-	Tool                 string      `json:"tool"`
-	Arguments            []string    `json:"arguments"`
-	Environment          []string    `json:"environment,omitempty"`
-	NotificationUrl      string      `json:"notification-url,omitempty"`
-	KVId                 string      `json:"key-value-id"`
+	InputFiles           []InputFile     `json:"input-files" proto:"1"`
+	OutputFileExtensions []string        `json:"output-file-extensions" proto:"2"`   // List of output files extensions
+	OutputClasses        []ArtifactClass `json:"output-classes,omitempty" proto:"3"` // Same order as extensions, OutputArtifact if missing
+	Tool                 string          `json:"tool" proto:"4"`
+	Arguments            []string        `json:"arguments" proto:"5"`
+	Environment          []string        `json:"environment,omitempty" proto:"6"`
+	NotificationUrl      string          `json:"notification-url,omitempty" proto:"7"`
+	KVId                 string          `json:"key-value-id" proto:"8"`
+	Owner                string          `json:"owner,omitempty" proto:"9"`      // Principal output files are attributed to
+	CacheKey             string          `json:"cache-key,omitempty" proto:"10"` // The result is stored in the compile cache under it
+}
+
+func (t *TaskMsg) OutputClass(i int) ArtifactClass {
+	if i < len(t.OutputClasses) && t.OutputClasses[i] != "" {
+		return t.OutputClasses[i]
+	}
+	return OutputArtifact
 }
 
 // TaskMsg.Arguments may contain placeholders for input and output files:
@@ -34,6 +46,8 @@ type TaskMsg struct {
 // Placeholders are purposely verbose and odd-looking to decrease change
 // of collision with "real" arguments
 
+var placeholderRegexp = regexp.MustCompile(`<(input|output)-file#(\d+)>`)
+
 func getInputFilenamePlaceholder(id int) string {
 	return "<input-file#" + strconv.Itoa(id) + ">"
 }
@@ -66,6 +80,23 @@ func (t *TaskMsg) ReplacePlaceholderFilenames(inputFileNames []string, outputFil
 	}
 }
 
+// CheckPlaceholders makes sure every placeholder refers to an existing input or output file
+func (t *TaskMsg) CheckPlaceholders() error {
+	for _, s := range append(append([]string{}, t.Arguments...), t.Environment...) {
+		for _, match := range placeholderRegexp.FindAllStringSubmatch(s, -1) {
+			id, err := strconv.Atoi(match[2])
+			files := len(t.InputFiles)
+			if match[1] == "output" {
+				files = len(t.OutputFileExtensions)
+			}
+			if err != nil || id >= files {
+				return fmt.Errorf("placeholder %s refers to a missing file", match[0])
+			}
+		}
+	}
+	return nil
+}
+
 func (t *TaskMsg) CreateEnv() []string {
 	var inherited []string
 	for _, e := range os.Environ() {
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// NotificationSignatureHeader carries "sha256=<hex digest>" of the request body
+const NotificationSignatureHeader = "X-Exec-Signature"
+
+// StatusNotification is POSTed to TaskMsg.NotificationUrl on every status transition
+type StatusNotification struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	ToolResultId string `json:"result-id,omitempty"`
+}
+
+func SignNotification(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyNotification is meant for webhook receivers
+func VerifyNotification(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignNotification(secret, body)), []byte(signature))
+}
@@ -0,0 +1,11 @@
+package cmd
+
+type NotificationConfig struct {
+	Secret         string   `json:"secret"`           // HMAC-SHA256 key used to sign webhook bodies
+	Timeout        Duration `json:"timeout"`          // Timeout of a single delivery attempt
+	MaxElapsedTime Duration `json:"max-elapsed-time"` // How long to keep retrying a failed delivery
+
+	// Lets webhooks reach loopback, private and link-local addresses, which are refused by default
+	// so that callers can't make workers POST to internal services
+	AllowPrivateAddresses bool `json:"allow-private-addresses"`
+}
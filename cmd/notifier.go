@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"exec/common"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultNotificationTimeout        = 10 * time.Second
+	defaultNotificationMaxElapsedTime = 2 * time.Minute
+)
+
+// Notifier delivers signed StatusNotification webhooks
+type Notifier struct {
+	client         *http.Client
+	secret         string
+	maxElapsedTime time.Duration
+}
+
+func NewNotifier(config *NotificationConfig) *Notifier {
+	timeout := config.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultNotificationTimeout
+	}
+	maxElapsedTime := config.MaxElapsedTime.Duration
+	if maxElapsedTime == 0 {
+		maxElapsedTime = defaultNotificationMaxElapsedTime
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !config.AllowPrivateAddresses {
+		// Checked on every connection with the resolved address, so DNS rebinding and redirects are covered too
+		dialer.Control = refusePrivateAddresses
+	}
+	return &Notifier{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		secret:         config.Secret,
+		maxElapsedTime: maxElapsedTime,
+	}
+}
+
+var errPrivateAddress = errors.New("webhooks to private addresses are not allowed")
+
+func refusePrivateAddresses(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errPrivateAddress, ip)
+	}
+	return nil
+}
+
+// Notify blocks until the webhook is delivered or retries are exhausted, so call it in a separate goroutine
+func (n *Notifier) Notify(notificationUrl string, id string, result *RunResult, logger *log.Logger) {
+	if notificationUrl == "" {
+		return
+	}
+	body, err := json.Marshal(&StatusNotification{
+		Id:           id,
+		Status:       result.Status.ToString(),
+		ToolResultId: result.ToolResultId,
+	})
+	if err != nil {
+		common.HandleErrLog(err, logger)
+		return
+	}
+
+	back := backoff.NewExponentialBackOff()
+	back.MaxElapsedTime = n.maxElapsedTime
+	err = backoff.Retry(
+		func() error {
+			return n.deliver(notificationUrl, body)
+		},
+		back,
+	)
+	if err != nil {
+		logger.Printf("Failed to deliver \"%s\" notification for %s to %s: %+v", result.Status.ToString(), id, notificationUrl, err)
+	}
+}
+
+func (n *Notifier) deliver(notificationUrl string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, notificationUrl, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		req.Header.Set(NotificationSignatureHeader, SignNotification(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return backoff.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	err = fmt.Errorf("webhook responded with %s", resp.Status)
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return err
+	default:
+		return backoff.Permanent(err)
+	}
+}
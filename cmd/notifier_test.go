@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/cenkalti/backoff/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotifierRefusesPrivateAddresses(t *testing.T) {
+	delivered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, _ *http.Request) {
+		delivered++
+	}))
+	defer server.Close()
+
+	err := NewNotifier(&NotificationConfig{}).deliver(server.URL, []byte("{}"))
+	var permanent *backoff.PermanentError
+	if !errors.Is(err, errPrivateAddress) || !errors.As(err, &permanent) {
+		t.Fatalf("webhook to %s got %v", server.URL, err)
+	}
+	if err = NewNotifier(&NotificationConfig{AllowPrivateAddresses: true}).deliver(server.URL, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if delivered != 1 {
+		t.Fatalf("delivered %d webhooks", delivered)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"exec/common"
+	nats2 "exec/nats"
+	"exec/storage"
+	"fmt"
+	"github.com/nats-io/nats.go"
+)
+
+// Backends of the object store
+const (
+	NatsObjectStore       = "nats"
+	FileSystemObjectStore = "file-system"
+	S3ObjectStore         = "s3"
+)
+
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	Bucket          string `json:"bucket"`
+	AccessKeyId     string `json:"access-key-id"`
+	SecretAccessKey string `json:"secret-access-key"`
+}
+
+// ObjectStoreConfig selects where artifacts are stored, the bucket of object-store-bucket-config is used by default
+type ObjectStoreConfig struct {
+	Backend        string   `json:"backend"`          // nats, file-system or s3, empty means nats
+	FileSystemPath string   `json:"file-system-path"` // Shared by the api and workers
+	S3Config       S3Config `json:"s3-config"`
+}
+
+// OpenObjectStore retries transient errors of the nats backend by policy
+func OpenObjectStore(
+	js nats.JetStreamContext,
+	config *ObjectStoreConfig,
+	bucketConfig *ObjectStoreBucketConfig,
+	policy *nats2.RetryPolicy,
+) (common.ObjectStore, error) {
+	switch config.Backend {
+	case "", NatsObjectStore:
+		osb, err := js.ObjectStore(bucketConfig.Name)
+		if err != nil {
+			return nil, err
+		}
+		return nats2.NewObjectStoreWrapper(osb, policy), nil
+	case FileSystemObjectStore:
+		return storage.NewFileSystemStore(config.FileSystemPath)
+	case S3ObjectStore:
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:        config.S3Config.Endpoint,
+			Region:          config.S3Config.Region,
+			Bucket:          config.S3Config.Bucket,
+			AccessKeyId:     config.S3Config.AccessKeyId,
+			SecretAccessKey: config.S3Config.SecretAccessKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown object store backend %q", config.Backend)
+	}
+}
@@ -0,0 +1,17 @@
+package cmd
+
+const (
+	StdoutStream = "stdout"
+	StderrStream = "stderr"
+)
+
+// OutputChunk is a piece of tool output published while the tool is running.
+// Delivery is best-effort, so Seq and Offset let subscribers notice lost chunks and how much output they carried
+type OutputChunk struct {
+	Seq       uint64 `json:"seq"`
+	Offset    uint64 `json:"offset,omitempty"` // Bytes of both streams published before this chunk
+	Stream    string `json:"stream,omitempty"`
+	Data      []byte `json:"data,omitempty"`      // Raw bytes, chunks may split multibyte characters
+	Eof       bool   `json:"eof,omitempty"`       // The tool has exited, no more chunks will follow
+	Truncated bool   `json:"truncated,omitempty"` // The rest of the output exceeds the streaming limit
+}
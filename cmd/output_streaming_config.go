@@ -0,0 +1,24 @@
+package cmd
+
+const defaultMaxStreamedBytes = 1 << 20
+
+type OutputStreamingConfig struct {
+	SubjectPrefix    string `json:"subject-prefix"`     // Chunks of the task are published to "<subject-prefix>.<key-value-id>", empty disables streaming
+	ChunkSize        int    `json:"chunk-size"`         // Max size of a single published chunk
+	MaxStreamedBytes int64  `json:"max-streamed-bytes"` // Output beyond this is only available through the buffered result
+}
+
+func (config *OutputStreamingConfig) Enabled() bool {
+	return config.SubjectPrefix != ""
+}
+
+func (config *OutputStreamingConfig) Subject(kvId string) string {
+	return config.SubjectPrefix + "." + kvId
+}
+
+func (config *OutputStreamingConfig) StreamedBytesLimit() int64 {
+	if config.MaxStreamedBytes <= 0 {
+		return defaultMaxStreamedBytes
+	}
+	return config.MaxStreamedBytes
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+)
+
+// QueuedTask is a task message still in the work queue stream, i.e. not acked yet
+type QueuedTask struct {
+	Raw  *nats.RawStreamMsg
+	Task *TaskMsg
+}
+
+// ScanQueue calls visit for every message in the stream, in order, until it returns false.
+// Each get returns the next message left, so acked ones cost nothing. Needs AllowDirect on the stream,
+// work queues don't allow consumers besides the workers
+func ScanQueue(js nats.JetStreamManager, stream string, serializer common.Serializer[TaskMsg], visit func(*QueuedTask) bool) error {
+	for seq := uint64(1); ; {
+		raw, err := js.GetMsg(stream, seq, nats.DirectGetNext(">"))
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		task, err := serializer.Deserialize(raw.Data)
+		if err != nil {
+			return err
+		}
+		if !visit(&QueuedTask{Raw: raw, Task: task}) {
+			return nil
+		}
+		seq = raw.Sequence + 1
+	}
+}
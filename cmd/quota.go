@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"exec/common"
+	"strings"
+	"time"
+)
+
+// QuotaUsage of a principal during a UTC day, stored in the key value bucket under QuotaKey
+type QuotaUsage struct {
+	CpuSeconds  float64 `json:"cpu-seconds"`
+	StoredBytes uint64  `json:"stored-bytes"`
+}
+
+// HashKeyPart turns arbitrary strings (principals, addresses) into valid key value bucket key tokens
+func HashKeyPart(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Prefixes of key value bucket keys which aren't tasks
+const (
+	QuotaKeyPrefix       = "quota."
+	RateLimitKeyPrefix   = "ratelimit."
+	IdempotencyKeyPrefix = "idempotency."
+)
+
+const quotaDayLayout = "2006-01-02"
+
+func QuotaKey(principal string, now time.Time) string {
+	return QuotaKeyPrefix + now.UTC().Format(quotaDayLayout) + "." + HashKeyPart(principal)
+}
+
+// QuotaKeyDay returns the UTC day counted by a QuotaKey
+func QuotaKeyDay(key string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(key, QuotaKeyPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	day, _, _ := strings.Cut(rest, ".")
+	t, err := time.Parse(quotaDayLayout, day)
+	return t, err == nil
+}
+
+// QuotaResetIn is the time left until usage counters start over
+func QuotaResetIn(now time.Time) time.Duration {
+	now = now.UTC()
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+func ChargeQuota(kvb common.KeyValueBucket[QuotaUsage], principal string, now time.Time, delta *QuotaUsage, ctx context.Context) error {
+	if *delta == (QuotaUsage{}) {
+		return nil // Like dropped results
+	}
+	_, err := Upsert(kvb, QuotaKey(principal, now), func(usage *QuotaUsage) error {
+		usage.CpuSeconds += delta.CpuSeconds
+		usage.StoredBytes += delta.StoredBytes
+		return nil
+	}, ctx)
+	return err
+}
@@ -0,0 +1,9 @@
+package cmd
+
+// RateLimitConfig zero values disable the corresponding limit
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests-per-second"` // Token bucket refill rate per principal, or per ip for anonymous clients
+	Burst             int     `json:"burst"`               // Token bucket capacity
+	DailyCpuSeconds   float64 `json:"daily-cpu-seconds"`   // Per principal
+	DailyStoredBytes  uint64  `json:"daily-stored-bytes"`  // Per principal
+}
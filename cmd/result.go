@@ -1,9 +1,11 @@
 package cmd
 
+import "strings"
+
 // ToolResult be stored in
 type ToolResult struct {
-	ToolOutput  string   `json:"tool-output"`
-	OutputFiles []string `json:"output-files"`
+	ToolOutput  string   `json:"tool-output" proto:"1"`
+	OutputFiles []string `json:"output-files" proto:"2"`
 }
 
 type RunStatus uint8
@@ -12,6 +14,8 @@ const (
 	Enqueued RunStatus = iota
 	Processing
 	Finished
+	Cancelled
+	Expired // Artifacts were garbage collected
 )
 
 func (s RunStatus) ToString() string {
@@ -22,11 +26,31 @@ func (s RunStatus) ToString() string {
 		return "processing"
 	case Finished:
 		return "finished"
+	case Cancelled:
+		return "cancelled"
+	case Expired:
+		return "expired"
 	}
 	return ""
 }
 
+// IsFinal statuses never change
+func (s RunStatus) IsFinal() bool {
+	return s == Finished || s == Cancelled || s == Expired
+}
+
+// IsTaskKey tells RunResult entries apart from the other entries of the bucket, like quotas, whose keys contain dots
+func IsTaskKey(key string) bool {
+	return !strings.Contains(key, ".")
+}
+
 type RunResult struct {
-	Status       RunStatus `json:"status"`
-	ToolResultId string    `json:"result-id"`
+	Status       RunStatus `json:"status" proto:"1"`
+	ToolResultId string    `json:"result-id" proto:"2"`
+	Owner        string    `json:"owner,omitempty" proto:"3"`
+	Published    bool      `json:"published,omitempty" proto:"4"` // Set for idempotent requests once the task is in the queue
+
+	// Chunks, the eof one included, and bytes of output published to the stream, lets readers tell what they missed
+	StreamedChunks uint64 `json:"streamed-chunks,omitempty" proto:"5"`
+	StreamedBytes  uint64 `json:"streamed-bytes,omitempty" proto:"6"`
 }
@@ -0,0 +1,27 @@
+package cmd
+
+import "time"
+
+// RetentionConfig zero durations keep things forever. Objects without a class are always kept
+type RetentionConfig struct {
+	Source     Duration `json:"source"`
+	Binary     Duration `json:"binary"`
+	Output     Duration `json:"output"`
+	Result     Duration `json:"result"`
+	Task       Duration `json:"task"`        // Key value entries of finished, cancelled and expired tasks, since their last change
+	GcInterval Duration `json:"gc-interval"` // How often the garbage collector runs
+}
+
+func (c *RetentionConfig) Of(class ArtifactClass) time.Duration {
+	switch class {
+	case SourceArtifact:
+		return c.Source.Duration
+	case BinaryArtifact:
+		return c.Binary.Duration
+	case OutputArtifact:
+		return c.Output.Duration
+	case ResultArtifact:
+		return c.Result.Duration
+	}
+	return 0
+}
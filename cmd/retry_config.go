@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	nats2 "exec/nats"
+	"log"
+	"time"
+)
+
+// RetryConfig bounds retries of transient nats errors, zero fields keep the defaults of nats2.DefaultRetryPolicy
+type RetryConfig struct {
+	MaxAttempts         int      `json:"max-attempts"` // Including the first one, 0 means until max-elapsed
+	MaxElapsed          Duration `json:"max-elapsed"`
+	InitialInterval     Duration `json:"initial-interval"`
+	MaxInterval         Duration `json:"max-interval"`
+	Multiplier          float64  `json:"multiplier"`
+	RandomizationFactor float64  `json:"randomization-factor"` // Jitter, the interval varies by this fraction
+}
+
+// Policy logs retries to logger unless it's nil
+func (c *RetryConfig) Policy(logger *log.Logger) *nats2.RetryPolicy {
+	policy := &nats2.RetryPolicy{
+		MaxAttempts:         c.MaxAttempts,
+		MaxElapsed:          c.MaxElapsed.Duration,
+		InitialInterval:     c.InitialInterval.Duration,
+		MaxInterval:         c.MaxInterval.Duration,
+		Multiplier:          c.Multiplier,
+		RandomizationFactor: c.RandomizationFactor,
+	}
+	if logger != nil {
+		policy.OnRetry = func(operation string, attempt int, err error, delay time.Duration) {
+			logger.Printf("Retrying %s in %v after attempt #%d failed: %v", operation, delay, attempt, err)
+		}
+	}
+	return policy
+}
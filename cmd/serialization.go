@@ -0,0 +1,35 @@
+package cmd
+
+import "exec/common"
+
+// SchemaVersion of TaskMsg, RunResult and ToolResult, written into the envelope of binary formats.
+// Proto tags of these types must never be changed or reused
+const SchemaVersion = 1
+
+// Serializers of the values shared by the api and the workers. They write the configured format and read all of them
+type Serializers struct {
+	Task       common.Serializer[TaskMsg]
+	Result     common.Serializer[RunResult]
+	ToolResult common.Serializer[ToolResult]
+}
+
+// NewSerializers accepts the names of common.ParseFormat
+func NewSerializers(format string) (*Serializers, error) {
+	f, err := common.ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	task, err := common.NewEnvelopeSerializer[TaskMsg](f, SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	result, err := common.NewEnvelopeSerializer[RunResult](f, SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	toolResult, err := common.NewEnvelopeSerializer[ToolResult](f, SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &Serializers{Task: task, Result: result, ToolResult: toolResult}, nil
+}
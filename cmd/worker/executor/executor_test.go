@@ -0,0 +1,207 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	nats2 "exec/nats"
+	"exec/nats/natstest"
+	"github.com/nats-io/nats.go"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const racers = 8
+
+func newResultKvb(t *testing.T) common.KeyValueBucket[cmd.RunResult] {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var retryConfig cmd.RetryConfig
+	return nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, &common.JsonSerializer[cmd.RunResult]{}, retryConfig.Policy(nil))
+}
+
+func TestChangeStatusToProcessingOnce(t *testing.T) {
+	kvb := newResultKvb(t)
+	if _, err := kvb.Create("task", &cmd.RunResult{Status: cmd.Enqueued}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redelivered copies of a task may be processed concurrently
+	logger := log.New(io.Discard, "", 0)
+	var wg sync.WaitGroup
+	var changed atomic.Int32
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if changeStatusToProcessing(kvb, "task", logger) {
+				changed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if changed.Load() != 1 {
+		t.Fatalf("expected a single change to be reported, got %d", changed.Load())
+	}
+
+	entry, err := kvb.Get("task", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Value().Status != cmd.Processing {
+		t.Fatalf("expected processing, got %s", entry.Value().Status.ToString())
+	}
+	if entry.Revision() != 2 {
+		t.Fatalf("expected a single update, got revision %d", entry.Revision())
+	}
+}
+
+func TestChangeStatusToProcessingKeepsFinalStatus(t *testing.T) {
+	kvb := newResultKvb(t)
+	logger := log.New(io.Discard, "", 0)
+	for _, final := range []cmd.RunStatus{cmd.Cancelled, cmd.Finished} {
+		key := "task-" + final.ToString()
+		if _, err := kvb.Create(key, &cmd.RunResult{Status: cmd.Enqueued}, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < racers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				changeStatusToProcessing(kvb, key, logger)
+			}()
+		}
+		final := final
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := kvb.CAS(
+				key,
+				func(result *cmd.RunResult) (bool, error) {
+					return result.Status.IsFinal(), nil
+				},
+				func(result *cmd.RunResult) error {
+					result.Status = final
+					return nil
+				},
+				context.Background(),
+			)
+			common.HandleErrLog(err, logger)
+		}()
+		wg.Wait()
+
+		entry, err := kvb.Get(key, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Value().Status != final {
+			t.Fatalf("expected %s, got %s", final.ToString(), entry.Value().Status.ToString())
+		}
+	}
+}
+
+func TestResultOfCancelledTaskIsDropped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obs, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "files"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var retryConfig cmd.RetryConfig
+	kvb := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kv, &common.JsonSerializer[cmd.RunResult]{}, retryConfig.Policy(nil))
+	osb := nats2.NewObjectStoreWrapper(obs, retryConfig.Policy(nil))
+	if _, err = kvb.Create("task", &cmd.RunResult{Status: cmd.Cancelled}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var notified []*cmd.RunResult
+	usage := cmd.QuotaUsage{CpuSeconds: 1}
+	err = uploadResultsAndNotify(
+		osb, kvb, nil, "output", &cmd.TaskMsg{KVId: "task"}, log.New(io.Discard, "", 0),
+		&common.JsonSerializer[cmd.ToolResult]{},
+		func(result *cmd.RunResult) {
+			notified = append(notified, result)
+		},
+		&usage, nil, nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 0 {
+		t.Fatalf("expected no notification of the dropped result, got %+v", notified)
+	}
+	objects, err := osb.List(context.Background())
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 || usage != (cmd.QuotaUsage{}) {
+		t.Fatalf("the result wasn't dropped: %d objects, %+v charged", len(objects), usage)
+	}
+}
+
+type recordingBroadcaster struct {
+	chunks []*cmd.OutputChunk
+}
+
+func (b *recordingBroadcaster) Broadcast(_ string, msg *cmd.OutputChunk) error {
+	// Through the wire format, like subscribers see it
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var chunk cmd.OutputChunk
+	if err = json.Unmarshal(data, &chunk); err != nil {
+		return err
+	}
+	b.chunks = append(b.chunks, &chunk)
+	return nil
+}
+
+func TestOutputStreamerKeepsBytes(t *testing.T) {
+	broadcaster := &recordingBroadcaster{}
+	config := &cmd.OutputStreamingConfig{SubjectPrefix: "output", ChunkSize: 3}
+	streamer := newOutputStreamer(broadcaster, config, "task", log.New(io.Discard, "", 0))
+	output := []byte("✓✓\xff\x00")
+	var buffer bytes.Buffer
+	if _, err := streamer.wrap(cmd.StdoutStream, &buffer).Write(output[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := streamer.wrap(cmd.StdoutStream, &buffer).Write(output[4:]); err != nil {
+		t.Fatal(err)
+	}
+	streamer.close()
+
+	var streamed []byte
+	for _, chunk := range broadcaster.chunks {
+		if chunk.Offset != uint64(len(streamed)) {
+			t.Fatalf("chunk %d is at offset %d instead of %d", chunk.Seq, chunk.Offset, len(streamed))
+		}
+		streamed = append(streamed, chunk.Data...)
+	}
+	if !bytes.Equal(streamed, output) || !bytes.Equal(buffer.Bytes(), output) {
+		t.Fatalf("streamed %q, buffered %q instead of %q", streamed, buffer.Bytes(), output)
+	}
+	if last := broadcaster.chunks[len(broadcaster.chunks)-1]; !last.Eof {
+		t.Fatalf("expected eof last, got %+v", last)
+	}
+}
@@ -0,0 +1,128 @@
+package executor
+
+import (
+	"bytes"
+	"exec/cmd"
+	"exec/common"
+	"io"
+	"log"
+	"sync"
+)
+
+const defaultOutputChunkSize = 4 << 10
+
+// outputStreamer publishes tool output as it is produced. Publishing is best-effort
+// and never fails the tool, the buffered output remains the source of truth
+type outputStreamer struct {
+	mu          sync.Mutex
+	broadcaster common.Broadcaster[cmd.OutputChunk]
+	subject     string
+	chunkSize   int
+	budget      int64 // Bytes left to publish
+	seq         uint64
+	offset      uint64
+	truncated   bool
+	failed      bool
+	logger      *log.Logger
+}
+
+func newOutputStreamer(
+	broadcaster common.Broadcaster[cmd.OutputChunk],
+	config *cmd.OutputStreamingConfig,
+	kvId string,
+	logger *log.Logger,
+) *outputStreamer {
+	if broadcaster == nil || !config.Enabled() {
+		return nil
+	}
+	chunkSize := config.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultOutputChunkSize
+	}
+	return &outputStreamer{
+		broadcaster: broadcaster,
+		subject:     config.Subject(kvId),
+		chunkSize:   chunkSize,
+		budget:      config.StreamedBytesLimit(),
+		logger:      logger,
+	}
+}
+
+// wrap tees writes to the buffer into the stream, nil streamer leaves the buffer as is
+func (s *outputStreamer) wrap(stream string, buffer io.Writer) io.Writer {
+	if s == nil {
+		return buffer
+	}
+	return io.MultiWriter(buffer, &outputStreamWriter{streamer: s, stream: stream})
+}
+
+func (s *outputStreamer) publish(chunk *cmd.OutputChunk) {
+	chunk.Seq = s.seq
+	chunk.Offset = s.offset
+	s.seq++
+	s.offset += uint64(len(chunk.Data))
+	if s.failed {
+		return
+	}
+	if err := s.broadcaster.Broadcast(s.subject, chunk); err != nil {
+		s.failed = true
+		s.logger.Printf("Failed to stream output to %s, giving up: %+v", s.subject, err)
+	}
+}
+
+func (s *outputStreamer) write(stream string, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.truncated {
+		return
+	}
+	for len(p) > 0 {
+		if s.budget == 0 {
+			s.truncated = true
+			s.publish(&cmd.OutputChunk{Truncated: true})
+			return
+		}
+		n := s.chunkSize
+		if n > len(p) {
+			n = len(p)
+		}
+		if int64(n) > s.budget {
+			n = int(s.budget)
+		}
+		s.publish(&cmd.OutputChunk{
+			Stream: stream,
+			Data:   bytes.Clone(p[:n]),
+		})
+		s.budget -= int64(n)
+		p = p[n:]
+	}
+}
+
+// streamed returns the published chunks and bytes, zero for nil streamer
+func (s *outputStreamer) streamed() (uint64, uint64) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq, s.offset
+}
+
+func (s *outputStreamer) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publish(&cmd.OutputChunk{Eof: true})
+}
+
+type outputStreamWriter struct {
+	streamer *outputStreamer
+	stream   string
+}
+
+func (w *outputStreamWriter) Write(p []byte) (int, error) {
+	w.streamer.write(w.stream, p)
+	return len(p), nil
+}
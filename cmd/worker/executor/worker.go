@@ -1,4 +1,4 @@
-package main
+package executor
 
 import (
 	"bytes"
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"exec/cmd"
 	"exec/common"
-	"github.com/nats-io/nats.go"
 	"log"
 	"os"
 	"os/exec"
@@ -14,21 +13,35 @@ import (
 	"time"
 )
 
-func worker(
+// Run processes tasks one by one until ctx is done, a task being processed is finished first
+func Run(
+	ctx context.Context,
 	sub common.PullSubscriber[cmd.TaskMsg],
-	osb nats.ObjectStore,
+	osb common.ObjectStore,
 	kvb common.KeyValueBucket[cmd.RunResult],
 	logger *log.Logger,
 	toolsPath string,
 	serializer common.Serializer[cmd.ToolResult],
+	notifier *cmd.Notifier,
+	broadcaster common.Broadcaster[cmd.OutputChunk],
+	streamingConfig *cmd.OutputStreamingConfig,
+	quotaKvb common.KeyValueBucket[cmd.QuotaUsage],
+	compileCache common.KeyValueBucket[cmd.CompileCacheEntry], // Nil if disabled
 ) {
 	var errorCount = 0
-	for {
+	for ctx.Err() == nil {
 		var cleanup common.Cleanup
 		{
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+			fetchCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 			cleanup.AddAction(func() { cancel() })
-			msgs, err := sub.Fetch(1, ctx)
+			msgs, err := sub.Fetch(1, fetchCtx)
+			if ctx.Err() != nil {
+				// Stopped, the messages are left to other workers
+				for _, msg := range msgs {
+					common.HandleErrLog(msg.NAck(), logger)
+				}
+				goto cleanup
+			}
 			if err != nil {
 				if errors.Is(err, context.DeadlineExceeded) {
 					logger.Printf("It's too boring")
@@ -51,10 +64,26 @@ func worker(
 			if err != nil {
 				goto cleanup
 			}
+			taskCtx, stopWatching := watchCancellation(kvb, content.KVId, logger)
+			cleanup.AddAction(stopWatching)
+			processingNotified := make(chan struct{})
 			go func() {
-				changeStatusToProcessing(kvb, content.KVId, logger)
-				notify(content.NotificationUrl, content.KVId, cmd.Processing, logger)
+				defer close(processingNotified)
+				if changeStatusToProcessing(kvb, content.KVId, logger) {
+					notifier.Notify(content.NotificationUrl, content.KVId, &cmd.RunResult{Status: cmd.Processing}, logger)
+				}
 			}() // I don't care if it'll be finished after the processing of the request as long as I perform CAS inside
+			notify := func(result *cmd.RunResult) {
+				go func() {
+					<-processingNotified // The final status mustn't arrive before the processing one
+					notifier.Notify(content.NotificationUrl, content.KVId, result, logger)
+				}()
+			}
+			skipCancelled := func() {
+				logger.Printf("Task %s was cancelled", content.KVId)
+				common.HandleErrLog(msg.Ack(), logger)
+				notify(&cmd.RunResult{Status: cmd.Cancelled})
+			}
 
 			inputFiles, err := fetchFiles(osb, content.InputFiles, logger)
 			{
@@ -91,14 +120,20 @@ func worker(
 				}
 			})
 			content.ReplacePlaceholderFilenames(inputFiles, outputFiles)
+			if isCancelled(taskCtx) {
+				skipCancelled()
+				goto cleanup
+			}
 
-			subProc := exec.Command(filepath.Join(toolsPath, content.Tool), content.Arguments...)
+			// Killed as soon as the task is cancelled
+			subProc := exec.CommandContext(taskCtx, filepath.Join(toolsPath, content.Tool), content.Arguments...)
 
 			subProc.Stdin = nil
+			streamer := newOutputStreamer(broadcaster, streamingConfig, content.KVId, logger)
 			stderr := new(bytes.Buffer)
-			subProc.Stderr = stderr
+			subProc.Stderr = streamer.wrap(cmd.StderrStream, stderr)
 			stdout := new(bytes.Buffer)
-			subProc.Stdout = stdout
+			subProc.Stdout = streamer.wrap(cmd.StdoutStream, stdout)
 
 			subProc.Env = content.CreateEnv()
 
@@ -111,6 +146,11 @@ func worker(
 				}
 			}
 			err = subProc.Wait()
+			streamer.close()
+			if isCancelled(taskCtx) {
+				skipCancelled()
+				goto cleanup
+			}
 			if err != nil {
 				var exitError *exec.ExitError
 				if errors.As(err, &exitError) {
@@ -122,12 +162,16 @@ func worker(
 			if stderr.Len() != 0 {
 				logger.Printf("Tool has non-empty error output \"%s\"", stderr.Bytes())
 			}
-			err = uploadResultsAndNotify(osb, kvb, outputFiles, stdout.String(), content, logger, serializer)
-
+			var usage cmd.QuotaUsage
+			if state := subProc.ProcessState; state != nil {
+				usage.CpuSeconds = (state.UserTime() + state.SystemTime()).Seconds()
+			}
+			err = uploadResultsAndNotify(osb, kvb, outputFiles, stdout.String(), content, logger, serializer, notify, &usage, compileCache, streamer)
 			if err != nil {
-				goto cleanup
+				goto cleanup // Redelivered, which charges the quota then
 			}
 			common.HandleErrLog(msg.Ack(), logger)
+			common.HandleErrLog(cmd.ChargeQuota(quotaKvb, content.Owner, time.Now(), &usage, context.Background()), logger)
 		}
 
 	cleanup:
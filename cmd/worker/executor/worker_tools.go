@@ -0,0 +1,219 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"exec/cmd"
+	"exec/common"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+const tmpPath = "/tmp"
+
+type ErrObjectNotFound struct {
+	ObjectId string
+}
+
+func (e *ErrObjectNotFound) Error() string {
+	return "object not found: " + e.ObjectId
+}
+
+// TODO: Parallelize
+func fetchFiles(osb common.ObjectStore, inputFiles []cmd.InputFile, logger *log.Logger) ([]string, error) {
+	var cleanup common.Cleanup
+	defer cleanup.Do()
+	var createdFiles []string
+
+	for _, fileInfo := range inputFiles {
+		id, ext := fileInfo.ObjectStoreId, fileInfo.Extension
+		fileName := filepath.Join(tmpPath, common.GetRandomId()+ext)
+		err := common.GetObjectFile(osb, id, fileName, context.Background())
+		if errors.Is(err, common.ErrObjectNotFound) {
+			return nil, &ErrObjectNotFound{ObjectId: id}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		createdFiles = append(createdFiles, fileName)
+		cleanup.AddAction(func() {
+			err := os.Remove(fileName)
+			if err != nil {
+				logger.Printf("Failed to delete temporary file %s due to %+v", fileName, err)
+			}
+		})
+	}
+	cleanup.Discard()
+
+	return createdFiles, nil
+}
+
+func createTmpFileNames(exts []string) []string {
+	result := make([]string, len(exts))
+	for i, ext := range exts {
+		result[i] = filepath.Join(tmpPath, common.GetRandomId()+ext)
+	}
+	return result
+}
+
+// changeStatusToProcessing tells whether the status was changed, which is false for redelivered copies of a task
+// and for tasks which are already final
+func changeStatusToProcessing(kvb common.KeyValueBucket[cmd.RunResult], key string, logger *log.Logger) bool {
+	changed := false
+	_, _, err := kvb.CAS(
+		key,
+		func(curState *cmd.RunResult) (bool, error) {
+			changed = false
+			return curState.Status != cmd.Enqueued, nil
+		},
+		func(curState *cmd.RunResult) error {
+			changed = true
+			curState.Status = cmd.Processing
+			return nil
+		},
+		context.Background(),
+	)
+	common.HandleErrLog(err, logger)
+	return changed && err == nil
+}
+
+var errTaskCancelled = errors.New("task was cancelled")
+
+// watchCancellation returns a context which is done once the task is cancelled through the api,
+// stop has to be called after the task is processed
+func watchCancellation(kvb common.KeyValueBucket[cmd.RunResult], key string, logger *log.Logger) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	entries, err := kvb.Watch(key, ctx)
+	if err != nil {
+		// The task just won't be interruptible
+		common.HandleErrLog(err, logger)
+		return ctx, func() { cancel(nil) }
+	}
+	go func() {
+		for entry := range entries {
+			if entry.Operation() != common.KeyValuePut {
+				return // Purged, which cancels first
+			}
+			if entry.Value().Status == cmd.Cancelled {
+				cancel(errTaskCancelled)
+				return
+			}
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+func isCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errTaskCancelled)
+}
+
+func uploadResultsAndNotify(
+	osb common.ObjectStore,
+	kvb common.KeyValueBucket[cmd.RunResult],
+	outputFiles []string,
+	stdout string,
+	msg *cmd.TaskMsg,
+	logger *log.Logger,
+	serializer common.Serializer[cmd.ToolResult],
+	notify func(result *cmd.RunResult),
+	usage *cmd.QuotaUsage,
+	compileCache common.KeyValueBucket[cmd.CompileCacheEntry],
+	streamer *outputStreamer,
+) error {
+	var toolResult cmd.ToolResult
+	toolResult.ToolOutput = stdout
+	toolResult.OutputFiles = make([]string, len(outputFiles))
+	var wg common.WorkGroup
+	for i, name := range outputFiles {
+		i := i
+		name := name
+		wg.Spawn(func() {
+			var idToWrite string
+			id, err := common.PutObjectFile(osb, cmd.ArtifactMeta(common.GetRandomId(), msg.Owner, msg.OutputClass(i)), name, context.Background())
+			if errors.Is(err, os.ErrNotExist) {
+				idToWrite = ""
+			} else if err != nil {
+				idToWrite = fmt.Sprintf("error: %+v", err)
+			} else {
+				idToWrite = id.Name
+				atomic.AddUint64(&usage.StoredBytes, id.Size)
+			}
+			toolResult.OutputFiles[i] = idToWrite
+		})
+	}
+	wg.Wait()
+
+	var runResult cmd.RunResult
+	runResult.Status = cmd.Finished
+	runResult.StreamedChunks, runResult.StreamedBytes = streamer.streamed()
+	{
+		object, err := common.PutTypedObject(osb, cmd.ArtifactMeta(common.GetRandomId(), msg.Owner, cmd.ResultArtifact), &toolResult, serializer, context.Background())
+		common.HandleErrLog(err, logger)
+		if err != nil {
+			return err
+		}
+		runResult.ToolResultId = object.Name
+		usage.StoredBytes += object.Size
+	}
+	stored, _, err := kvb.CAS(
+		msg.KVId,
+		func(currentResult *cmd.RunResult) (bool, error) {
+			return currentResult.Status.IsFinal(), nil
+		},
+		func(result *cmd.RunResult) error {
+			result.Status = runResult.Status
+			result.ToolResultId = runResult.ToolResultId
+			result.StreamedChunks = runResult.StreamedChunks
+			result.StreamedBytes = runResult.StreamedBytes
+			return nil
+		},
+		context.Background(),
+	)
+	common.HandleErrLog(err, logger)
+	if err != nil {
+		return err
+	}
+	if stored.ToolResultId != runResult.ToolResultId {
+		// Cancelled or finished by a redelivered copy meanwhile, nothing refers to the uploads.
+		// Whichever got there first has notified already
+		logger.Printf("Task %s is already %s, dropping its result", msg.KVId, stored.Status.ToString())
+		for _, name := range append(toolResult.OutputFiles, runResult.ToolResultId) {
+			if name != "" && !strings.HasPrefix(name, "error: ") {
+				common.HandleErrLog(osb.Delete(name, context.Background()), logger)
+			}
+		}
+		*usage = cmd.QuotaUsage{} // Charged to the copy which stored its result
+		return nil
+	}
+	storeInCompileCache(compileCache, msg.CacheKey, runResult.ToolResultId, &toolResult, logger)
+	notify(&runResult)
+	return nil
+}
+
+// storeInCompileCache skips results with failed uploads, which aren't reproducible
+func storeInCompileCache(
+	compileCache common.KeyValueBucket[cmd.CompileCacheEntry],
+	key string,
+	toolResultId string,
+	toolResult *cmd.ToolResult,
+	logger *log.Logger,
+) {
+	if compileCache == nil || key == "" {
+		return
+	}
+	for _, name := range toolResult.OutputFiles {
+		if strings.HasPrefix(name, "error: ") {
+			return
+		}
+	}
+	_, err := cmd.Upsert(compileCache, key, func(entry *cmd.CompileCacheEntry) error {
+		entry.ToolResultId = toolResultId
+		return nil
+	}, context.Background())
+	common.HandleErrLog(err, logger)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"exec/cmd"
+	"exec/cmd/worker/executor"
 	"exec/common"
 	nats2 "exec/nats"
 	"flag"
@@ -25,6 +27,8 @@ func main() {
 	env := cmd.ParseEnvironment(os.Environ())
 	var workerConfig cmd.WorkerConfig
 	common.HandlePanic(cmd.ParseConfigFileWithRespectToEnv(*configPath, env, &workerConfig))
+	serializers, err := cmd.NewSerializers(workerConfig.SerializationFormat)
+	common.HandlePanic(err)
 
 	nc, err := workerConfig.ConnectionConfig.Connect()
 	common.HandlePanic(err)
@@ -33,19 +37,33 @@ func main() {
 	js, err := nc.JetStream()
 	common.HandlePanic(err)
 
-	osb, err := js.ObjectStore(workerConfig.ObjectStoreBucketConfig.Name)
+	kvb, err := js.KeyValue(workerConfig.KeyValueBucketConfig.Name)
 	common.HandlePanic(err)
 
-	kvb, err := js.KeyValue(workerConfig.KeyValueBucketConfig.Name)
+	retryPolicy := workerConfig.RetryConfig.Policy(log.New(os.Stderr, "Retry: ", log.LstdFlags|log.LUTC|log.Lmsgprefix|log.Lmicroseconds))
+
+	osb, err := cmd.OpenObjectStore(js, &workerConfig.ObjectStoreConfig, &workerConfig.ObjectStoreBucketConfig, retryPolicy)
 	common.HandlePanic(err)
 
-	typedKVB := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kvb, &common.JsonSerializer[cmd.RunResult]{})
+	resultsLogger := log.New(os.Stderr, "Results: ", log.LstdFlags|log.LUTC|log.Lmsgprefix|log.Lmicroseconds)
+	typedKVB := nats2.NewKeyValueTypedWrapper[cmd.RunResult](kvb, serializers.Result, retryPolicy, common.WithLogger(resultsLogger))
+	quotaKVB := nats2.NewKeyValueTypedWrapper[cmd.QuotaUsage](kvb, &common.JsonSerializer[cmd.QuotaUsage]{}, retryPolicy)
 
 	consumerConfig := workerConfig.ConsumerConfig
 	sub, err := js.PullSubscribe("", consumerConfig.Name, nats.Bind(consumerConfig.StreamName, consumerConfig.Name))
 	common.HandlePanic(err)
 
-	typedSub := nats2.NewSubscriptionWrapper[cmd.TaskMsg](sub, &common.JsonSerializer[cmd.TaskMsg]{})
+	typedSub := nats2.NewSubscriptionWrapper[cmd.TaskMsg](sub, serializers.Task, retryPolicy)
+
+	var compileCache common.KeyValueBucket[cmd.CompileCacheEntry]
+	if workerConfig.CompileCacheConfig.Enabled() {
+		cacheKv, err := js.KeyValue(workerConfig.CompileCacheConfig.KeyValueBucketConfig.Name)
+		common.HandlePanic(err)
+		compileCache = nats2.NewKeyValueTypedWrapper[cmd.CompileCacheEntry](cacheKv, &common.JsonSerializer[cmd.CompileCacheEntry]{}, retryPolicy)
+	}
+
+	notifier := cmd.NewNotifier(&workerConfig.NotificationConfig)
+	broadcaster := nats2.NewBroadcasterWrapper[cmd.OutputChunk](nc, &common.JsonSerializer[cmd.OutputChunk]{})
 
 	var wg common.WorkGroup
 	for i := 0; i < workerConfig.WorkerThreads; i++ {
@@ -56,7 +74,7 @@ func main() {
 				fmt.Sprintf("Worker #%d: ", i),
 				log.LstdFlags|log.LUTC|log.Lmsgprefix|log.Lmicroseconds,
 			)
-			worker(typedSub, osb, typedKVB, logger, workerConfig.PathToTools, &common.JsonSerializer[cmd.ToolResult]{})
+			executor.Run(context.Background(), typedSub, osb, typedKVB, logger, workerConfig.PathToTools, serializers.ToolResult, notifier, broadcaster, &workerConfig.OutputStreamingConfig, quotaKVB, compileCache)
 		})
 	}
 
@@ -3,8 +3,15 @@ package cmd
 type WorkerConfig struct {
 	WorkerThreads           int                     `json:"worker-threads"`
 	PathToTools             string                  `json:"path-to-tools"`
+	SerializationFormat     string                  `json:"serialization-format"` // Of tasks, results and tool results, all formats are read
 	ConsumerConfig          ConsumerConfig          `json:"consumer-config"`
 	ConnectionConfig        ConnectionConfig        `json:"connection-config"`
 	ObjectStoreBucketConfig ObjectStoreBucketConfig `json:"object-store-bucket-config"`
+	ObjectStoreConfig       ObjectStoreConfig       `json:"object-store-config"`
 	KeyValueBucketConfig    KeyValueBucketConfig    `json:"key-value-bucket-config"`
+	NotificationConfig      NotificationConfig      `json:"notification-config"`
+	OutputStreamingConfig   OutputStreamingConfig   `json:"output-streaming-config"`
+	RetentionConfig         RetentionConfig         `json:"retention-config"`
+	CompileCacheConfig      CompileCacheConfig      `json:"compile-cache-config"`
+	RetryConfig             RetryConfig             `json:"retry-config"`
 }
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxNestedLevels of arrays and maps accepted by the binary formats
+const maxNestedLevels = 32
+
+var (
+	// Map keys are sorted so the encoding is deterministic, times keep their nanoseconds
+	cborEncMode = mustCborMode(cbor.EncOptions{Sort: cbor.SortBytewiseLexical, Time: cbor.TimeRFC3339Nano}.EncMode())
+	cborDecMode = mustCborMode(cbor.DecOptions{MaxNestedLevels: maxNestedLevels}.DecMode())
+)
+
+func mustCborMode[T any](mode T, err error) T {
+	HandlePanic(err)
+	return mode
+}
+
+// CborSerializer encodes structs as CBOR maps keyed by their json names
+type CborSerializer[T any] struct{}
+
+func (*CborSerializer[T]) Serialize(value *T) ([]byte, error) {
+	return cborEncMode.Marshal(value)
+}
+
+func (*CborSerializer[T]) Deserialize(data []byte) (*T, error) {
+	var ret T
+	if err := cborDecMode.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -3,10 +3,14 @@ package common
 import (
 	"context"
 	"errors"
+	"io"
+	"log"
+	"net/http"
 )
 
 type Message[T any] interface {
 	Content() *T
+	Headers() http.Header // Empty if the message has none
 	Ack() error
 	NAck() error
 	AckInProgress() error
@@ -16,27 +20,130 @@ type PullSubscriber[T any] interface {
 	Fetch(n int, ctx context.Context) ([]Message[T], error)
 }
 
+// PublishOptions are collected from PublishOpt by publishers
+type PublishOptions struct {
+	MsgId          string // Messages with the id of a recent one are dropped, so retried publishes don't duplicate it
+	Headers        http.Header
+	ExpectedStream string // Publishing fails unless the subject belongs to this stream
+}
+
+type PublishOpt func(*PublishOptions)
+
+func WithMsgId(id string) PublishOpt {
+	return func(options *PublishOptions) {
+		options.MsgId = id
+	}
+}
+
+// WithHeader sets a header, e.g. trace context, priority or tenant. The key is kept as it is,
+// unlike keys of http headers the ones of nats are case-sensitive
+func WithHeader(key string, value string) PublishOpt {
+	return func(options *PublishOptions) {
+		if options.Headers == nil {
+			options.Headers = make(http.Header)
+		}
+		options.Headers[key] = []string{value}
+	}
+}
+
+func WithExpectedStream(stream string) PublishOpt {
+	return func(options *PublishOptions) {
+		options.ExpectedStream = stream
+	}
+}
+
+func NewPublishOptions(opts ...PublishOpt) *PublishOptions {
+	options := &PublishOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
 type Publisher[T any] interface {
-	PublishSync(subject string, msg *T) error
+	// PublishSync returns once the message is stored, publishing a duplicate of a message with the same msg id
+	// succeeds without storing it
+	PublishSync(subject string, msg *T, opts ...PublishOpt) error
+}
+
+// Broadcaster publishes messages without persistence or delivery guarantees
+type Broadcaster[T any] interface {
+	Broadcast(subject string, msg *T) error
+}
+
+// Listener delivers messages broadcast on the subject until ctx is done.
+// Messages arriving while bufferSize of them are pending are dropped
+type Listener[T any] interface {
+	Listen(subject string, bufferSize int, ctx context.Context) (<-chan *T, error)
 }
 
+// KeyValueOp is the operation which produced a key value entry
+type KeyValueOp uint8
+
+const (
+	KeyValuePut KeyValueOp = iota
+	KeyValueDelete
+	KeyValuePurge
+)
+
 type KeyValueEntry[T any] interface {
 	Key() string
-	Value() *T
+	Value() *T // Nil for deletes and purges
 	Revision() uint64
+	Operation() KeyValueOp
 }
 
 var ErrWrongRevNumber = errors.New("revision number mismatch")
 
+// KeyValueOptions are collected from KeyValueOpt by key value buckets
+type KeyValueOptions struct {
+	Logger *log.Logger // Reports entries skipped by watches
+}
+
+type KeyValueOpt func(*KeyValueOptions)
+
+func WithLogger(logger *log.Logger) KeyValueOpt {
+	return func(options *KeyValueOptions) {
+		options.Logger = logger
+	}
+}
+
+// NewKeyValueOptions discards logs unless a logger is given
+func NewKeyValueOptions(opts ...KeyValueOpt) *KeyValueOptions {
+	options := &KeyValueOptions{Logger: log.New(io.Discard, "", 0)}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
 type KeyValueBucket[T any] interface {
-	Get(key string) (KeyValueEntry[T], error)
-	Create(key string, value *T) (uint64, error)
+	Get(key string, ctx context.Context) (KeyValueEntry[T], error)
+	Create(key string, value *T, ctx context.Context) (uint64, error)
 
 	// Update on revision number mismatch returns ErrWrongRevNumber
-	Update(key string, value *T, last uint64) (uint64, error)
+	Update(key string, value *T, last uint64, ctx context.Context) (uint64, error)
 	CAS(
 		key string,
 		stopPredicate func(*T) (bool, error),
 		alter func(*T) error,
+		ctx context.Context,
 	) (*T, uint64, error)
+
+	// Watch sends the current values of the keys matching key, which may contain the wildcards of nats subjects,
+	// followed by all of their updates, deletes and purges included. The channel is closed once ctx is done,
+	// entries which can't be decoded are skipped and reported to the logger of the bucket
+	Watch(key string, ctx context.Context) (<-chan KeyValueEntry[T], error)
+
+	// Keys of the entries which aren't deleted, nil if there are none
+	Keys(ctx context.Context) ([]string, error)
+
+	// History of the key oldest first, as much of it as the bucket keeps. Fails with nats.ErrKeyNotFound
+	// if there's none, a purge leaves only itself
+	History(key string, ctx context.Context) ([]KeyValueEntry[T], error)
+
+	// Delete keeps the history of the key, Purge drops it. Non-zero last makes both fail with ErrWrongRevNumber
+	// unless it's the revision of the latest entry
+	Delete(key string, last uint64, ctx context.Context) error
+	Purge(key string, last uint64, ctx context.Context) error
 }
@@ -0,0 +1,21 @@
+package common
+
+import "testing"
+
+func TestWithHeaderWithoutNewPublishOptions(t *testing.T) {
+	var options PublishOptions
+	WithHeader("Priority", "high")(&options)
+	if options.Headers.Get("Priority") != "high" {
+		t.Fatalf("headers %v", options.Headers)
+	}
+}
+
+func TestWithHeaderKeepsKeyCase(t *testing.T) {
+	options := NewPublishOptions(WithHeader("x-trace-id", "1"), WithHeader("x-trace-id", "2"))
+	if values := options.Headers["x-trace-id"]; len(values) != 1 || values[0] != "2" || len(options.Headers) != 1 {
+		t.Fatalf("headers %v", options.Headers)
+	}
+	if NewPublishOptions(WithMsgId("id")).Headers != nil {
+		t.Fatal("headers allocated without any")
+	}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// timestampExtId of the MessagePack timestamp extension
+const timestampExtId = -1
+
+func init() {
+	// Timestamps are decoded in UTC like by the other formats, msgpack decodes them in the local time zone
+	msgpack.RegisterExtDecoder(timestampExtId, time.Time{}, func(d *msgpack.Decoder, v reflect.Value, extLen int) error {
+		data := make([]byte, extLen)
+		if err := d.ReadFull(data); err != nil {
+			return err
+		}
+		var sec, nsec uint64
+		switch extLen {
+		case 4:
+			sec = uint64(binary.BigEndian.Uint32(data))
+		case 8:
+			sec = binary.BigEndian.Uint64(data) & (1<<34 - 1)
+			nsec = binary.BigEndian.Uint64(data) >> 34
+		case 12:
+			nsec = uint64(binary.BigEndian.Uint32(data))
+			sec = binary.BigEndian.Uint64(data[4:])
+		default:
+			return fmt.Errorf("msgpack: timestamp of %d bytes", extLen)
+		}
+		v.Set(reflect.ValueOf(time.Unix(int64(sec), int64(nsec)).UTC()))
+		return nil
+	})
+}
+
+// MsgPackSerializer encodes structs as MessagePack maps keyed by their json names
+type MsgPackSerializer[T any] struct{}
+
+func (*MsgPackSerializer[T]) Serialize(value *T) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*MsgPackSerializer[T]) Deserialize(data []byte) (*T, error) {
+	if err := checkMsgPackNesting(data); err != nil {
+		return nil, err
+	}
+	var ret T
+	r := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&ret); err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("msgpack: %d bytes of trailing data", r.Len())
+	}
+	return &ret, nil
+}
+
+// checkMsgPackNesting walks the arrays and maps without recursion, msgpack decodes and skips them recursively
+// without a limit on their nesting
+func checkMsgPackNesting(data []byte) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	left := []int{1} // Values left at every level of nesting
+	for len(left) != 0 {
+		if left[len(left)-1] == 0 {
+			left = left[:len(left)-1]
+			continue
+		}
+		left[len(left)-1]--
+		code, err := dec.PeekCode()
+		if err != nil {
+			return err
+		}
+		var n int
+		switch {
+		case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+			n, err = dec.DecodeArrayLen()
+		case msgpcode.IsFixedMap(code) || code == msgpcode.Map16 || code == msgpcode.Map32:
+			n, err = dec.DecodeMapLen()
+			n *= 2
+		default:
+			if err = dec.Skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(left) > maxNestedLevels {
+			return fmt.Errorf("msgpack: more than %d nested levels", maxNestedLevels)
+		}
+		left = append(left, n)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// objectReadSeeker streams an object, seeking forward by skipping and backward by streaming from the new position
+type objectReadSeeker struct {
+	ctx    context.Context
+	store  ObjectStore
+	name   string
+	size   int64
+	reader io.ReadCloser // Nil until the first read after opening or seeking back
+	offset int64         // Of reader
+	pos    int64         // Requested by Seek
+}
+
+// NewObjectReadSeeker makes objects usable with http.ServeContent. Seeking is lazy,
+// so only the ranges actually read are streamed
+func NewObjectReadSeeker(store ObjectStore, info *ObjectInfo, ctx context.Context) io.ReadSeekCloser {
+	return &objectReadSeeker{ctx: ctx, store: store, name: info.Name, size: int64(info.Size)}
+}
+
+func (r *objectReadSeeker) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader != nil && r.offset > r.pos {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if r.reader == nil {
+		reader, err := r.store.Stream(r.name, r.pos, r.ctx)
+		if err != nil {
+			return 0, err
+		}
+		r.reader = reader
+		r.offset = r.pos
+	}
+	if skip := r.pos - r.offset; skip > 0 {
+		n, err := io.CopyN(io.Discard, r.reader, skip)
+		r.offset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	r.pos = r.offset
+	return n, err
+}
+
+func (r *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func (r *objectReadSeeker) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
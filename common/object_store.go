@@ -0,0 +1,93 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrObjectNotFound is returned for objects that don't exist or were deleted
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectMeta struct {
+	Name    string
+	Headers http.Header
+}
+
+type ObjectInfo struct {
+	ObjectMeta
+	Size    uint64
+	Digest  string // Of the content, see ObjectDigest
+	ModTime time.Time
+}
+
+// ObjectStore stores objects by name, putting an object replaces the one of the same name
+type ObjectStore interface {
+	Put(meta *ObjectMeta, data io.Reader, ctx context.Context) (*ObjectInfo, error)
+
+	// Get loads the whole object to memory, large objects should be streamed instead
+	Get(name string, ctx context.Context) ([]byte, error)
+	GetInfo(name string, ctx context.Context) (*ObjectInfo, error)
+	Delete(name string, ctx context.Context) error
+
+	// Stream reads the object from offset on, the reader has to be closed
+	Stream(name string, offset int64, ctx context.Context) (io.ReadCloser, error)
+
+	// List returns the info of all objects in no particular order
+	List(ctx context.Context) ([]*ObjectInfo, error)
+}
+
+// ObjectDigest formats the SHA-256 sum of content the way nats object stores do,
+// so digests don't change with the backend
+func ObjectDigest(sum []byte) string {
+	return "SHA-256=" + base64.URLEncoding.EncodeToString(sum)
+}
+
+func GetTypedObject[T any](store ObjectStore, name string, serializer Serializer[T], ctx context.Context) (*T, error) {
+	data, err := store.Get(name, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return serializer.Deserialize(data)
+}
+
+func PutTypedObject[T any](store ObjectStore, meta *ObjectMeta, content *T, serializer Serializer[T], ctx context.Context) (*ObjectInfo, error) {
+	data, err := serializer.Serialize(content)
+	if err != nil {
+		return nil, err
+	}
+	return store.Put(meta, bytes.NewReader(data), ctx)
+}
+
+// GetObjectFile streams the object to the file, which is created or truncated
+func GetObjectFile(store ObjectStore, name string, filePath string, ctx context.Context) error {
+	reader, err := store.Stream(name, 0, ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// PutObjectFile stores the file without loading it to memory
+func PutObjectFile(store ObjectStore, meta *ObjectMeta, filePath string, ctx context.Context) (*ObjectInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return store.Put(meta, file, ctx)
+}
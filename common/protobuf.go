@@ -0,0 +1,346 @@
+package common
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProtobufSerializer encodes structs as protobuf messages, field numbers are taken from proto struct tags.
+// Zero values are omitted like in proto3, numeric slices are packed and maps are repeated key (1), value (2)
+// entries. Pointers to scalars are always written
+type ProtobufSerializer[T any] struct{}
+
+func (*ProtobufSerializer[T]) Serialize(value *T) ([]byte, error) {
+	v := reflect.ValueOf(value).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("protobuf: %s isn't a message", v.Type())
+	}
+	data, err := appendMessage(nil, v)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf: %w", err)
+	}
+	return data, nil
+}
+
+func (*ProtobufSerializer[T]) Deserialize(data []byte) (*T, error) {
+	var ret T
+	v := reflect.ValueOf(&ret).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("protobuf: %s isn't a message", v.Type())
+	}
+	if err := consumeMessage(data, v); err != nil {
+		return nil, fmt.Errorf("protobuf: %w", err)
+	}
+	return &ret, nil
+}
+
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	for _, field := range structFields(v.Type()) {
+		if field.number < int(protowire.MinValidNumber) || field.number > int(protowire.MaxValidNumber) {
+			return nil, fmt.Errorf("field %s of %s has no valid proto tag", field.name, v.Type())
+		}
+		var err error
+		b, err = appendField(b, protowire.Number(field.number), v.FieldByIndex(field.index), false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// isPackable tells whether repeated values of kind are packed into a single field
+func isPackable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// appendField appends v as field num, zero values are skipped unless always is set
+func appendField(b []byte, num protowire.Number, v reflect.Value, always bool) ([]byte, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			if always {
+				return nil, fmt.Errorf("nil %s in a repeated field", v.Type())
+			}
+			return b, nil
+		}
+		return appendField(b, num, v.Elem(), true)
+	}
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		if len(text) == 0 && !always {
+			return b, nil
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), text), nil
+	}
+	if isPackable(v.Kind()) {
+		if v.IsZero() && !always {
+			return b, nil
+		}
+		return appendScalar(protowire.AppendTag(b, num, wireType(v.Kind())), v), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() == 0 && !always {
+			return b, nil
+		}
+		return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), v.String()), nil
+	case reflect.Slice:
+		elemKind := v.Type().Elem().Kind()
+		if elemKind == reflect.Uint8 {
+			if v.Len() == 0 && !always {
+				return b, nil
+			}
+			return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), v.Bytes()), nil
+		}
+		if v.Len() == 0 {
+			return b, nil
+		}
+		if isPackable(elemKind) {
+			var packed []byte
+			for i := 0; i < v.Len(); i++ {
+				packed = appendScalar(packed, v.Index(i))
+			}
+			return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), packed), nil
+		}
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendField(b, num, v.Index(i), true); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
+			entry, err := appendField(nil, 1, key, true)
+			if err != nil {
+				return nil, err
+			}
+			if entry, err = appendField(entry, 2, v.MapIndex(key), true); err != nil {
+				return nil, err
+			}
+			b = protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), entry)
+		}
+		return b, nil
+	case reflect.Struct:
+		message, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		if len(message) == 0 && !always {
+			return b, nil
+		}
+		return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), message), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func wireType(kind reflect.Kind) protowire.Type {
+	switch kind {
+	case reflect.Float32:
+		return protowire.Fixed32Type
+	case reflect.Float64:
+		return protowire.Fixed64Type
+	}
+	return protowire.VarintType
+}
+
+func appendScalar(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return protowire.AppendVarint(b, uint64(v.Int()))
+	case reflect.Float32:
+		return protowire.AppendFixed32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+	}
+	return protowire.AppendVarint(b, v.Uint())
+}
+
+func consumeMessage(b []byte, v reflect.Value) error {
+	fields := structFields(v.Type())
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = -1
+		for _, field := range fields {
+			if field.number == int(num) {
+				var err error
+				if n, err = consumeField(b, typ, v.FieldByIndex(field.index)); err != nil {
+					return fmt.Errorf("field %s of %s: %w", field.name, v.Type(), err)
+				}
+				break
+			}
+		}
+		if n < 0 {
+			// Written by a newer version
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeField decodes a value of wire type typ into v, which is appended to for repeated fields
+func consumeField(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return consumeField(b, typ, v.Elem())
+	}
+	isText := reflect.PointerTo(v.Type()).Implements(textUnmarshalerType)
+	if isPackable(v.Kind()) && !isText {
+		return consumeScalar(b, typ, v)
+	}
+
+	var data []byte
+	n := -1
+	if typ == protowire.BytesType {
+		data, n = protowire.ConsumeBytes(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+	}
+	isBytes := v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+	if n < 0 && (isBytes || isText || v.Kind() == reflect.String || v.Kind() == reflect.Map || v.Kind() == reflect.Struct) {
+		return 0, fmt.Errorf("unexpected wire type %d for %s", typ, v.Type())
+	}
+
+	switch {
+	case isText:
+		return n, v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+	case isBytes:
+		v.SetBytes(append([]byte{}, data...))
+		return n, nil
+	case v.Kind() == reflect.String:
+		v.SetString(string(data))
+		return n, nil
+	case v.Kind() == reflect.Struct:
+		return n, consumeMessage(data, v)
+	case v.Kind() == reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return 0, fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		key := reflect.New(v.Type().Key()).Elem()
+		elem := reflect.New(v.Type().Elem()).Elem()
+		for len(data) > 0 {
+			num, typ, tagLen := protowire.ConsumeTag(data)
+			if tagLen < 0 {
+				return 0, protowire.ParseError(tagLen)
+			}
+			data = data[tagLen:]
+			var valueLen int
+			var err error
+			switch num {
+			case 1:
+				valueLen, err = consumeField(data, typ, key)
+			case 2:
+				valueLen, err = consumeField(data, typ, elem)
+			default:
+				if valueLen = protowire.ConsumeFieldValue(num, typ, data); valueLen < 0 {
+					err = protowire.ParseError(valueLen)
+				}
+			}
+			if err != nil {
+				return 0, err
+			}
+			data = data[valueLen:]
+		}
+		v.SetMapIndex(key, elem)
+		return n, nil
+	case v.Kind() == reflect.Slice:
+		elemType := v.Type().Elem()
+		if isPackable(elemType.Kind()) && n >= 0 {
+			for len(data) > 0 {
+				elem := reflect.New(elemType).Elem()
+				elemLen, err := consumeScalar(data, wireType(elemType.Kind()), elem)
+				if err != nil {
+					return 0, err
+				}
+				data = data[elemLen:]
+				v.Set(reflect.Append(v, elem))
+			}
+			return n, nil
+		}
+		elem := reflect.New(elemType).Elem()
+		elemLen, err := consumeField(b, typ, elem)
+		if err != nil {
+			return 0, err
+		}
+		v.Set(reflect.Append(v, elem))
+		return elemLen, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func consumeScalar(b []byte, typ protowire.Type, v reflect.Value) (int, error) {
+	if typ != wireType(v.Kind()) {
+		return 0, fmt.Errorf("unexpected wire type %d for %s", typ, v.Type())
+	}
+	var x uint64
+	var n int
+	switch typ {
+	case protowire.Fixed32Type:
+		var x32 uint32
+		x32, n = protowire.ConsumeFixed32(b)
+		x = uint64(x32)
+	case protowire.Fixed64Type:
+		x, n = protowire.ConsumeFixed64(b)
+	default:
+		x, n = protowire.ConsumeVarint(b)
+	}
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(protowire.DecodeBool(x))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(int64(x)) {
+			return 0, fmt.Errorf("%d overflows %s", int64(x), v.Type())
+		}
+		v.SetInt(int64(x))
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(x))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(x))
+	default:
+		if v.OverflowUint(x) {
+			return 0, fmt.Errorf("%d overflows %s", x, v.Type())
+		}
+		v.SetUint(x)
+	}
+	return n, nil
+}
@@ -1,6 +1,11 @@
 package common
 
-import "encoding/json"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
 
 type Serializer[T any] interface {
 	Serialize(value *T) ([]byte, error)
@@ -20,3 +25,107 @@ func (*JsonSerializer[T]) Deserialize(data []byte) (*T, error) {
 	}
 	return &ret, nil
 }
+
+// Format of a serialized value, the first byte of its envelope. None of them is a valid start of json
+type Format byte
+
+const (
+	FormatJson Format = iota + 1
+	FormatMsgPack
+	FormatCbor
+	FormatProtobuf
+)
+
+var formatNames = map[Format]string{
+	FormatJson:     "json",
+	FormatMsgPack:  "msgpack",
+	FormatCbor:     "cbor",
+	FormatProtobuf: "protobuf",
+}
+
+var errMalformedEnvelope = errors.New("malformed envelope")
+
+// ParseFormat accepts the names returned by Format.String, empty means json
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatJson, nil
+	}
+	for format, formatName := range formatNames {
+		if formatName == name {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown serialization format %q", name)
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("format(%d)", byte(f))
+}
+
+func newFormatSerializer[T any](format Format) Serializer[T] {
+	switch format {
+	case FormatJson:
+		return &JsonSerializer[T]{}
+	case FormatMsgPack:
+		return &MsgPackSerializer[T]{}
+	case FormatCbor:
+		return &CborSerializer[T]{}
+	case FormatProtobuf:
+		return &ProtobufSerializer[T]{}
+	}
+	return nil
+}
+
+// EnvelopeSerializer writes values in one format, prefixed by the format and the uvarint schema version, and reads
+// values of every format, so nodes configured with different formats understand each other during rolling upgrades.
+// Json is written bare, as nodes which predate the envelope expect it
+type EnvelopeSerializer[T any] struct {
+	format  Format
+	version uint64
+	writer  Serializer[T]
+}
+
+func NewEnvelopeSerializer[T any](format Format, version uint64) (*EnvelopeSerializer[T], error) {
+	writer := newFormatSerializer[T](format)
+	if writer == nil {
+		return nil, fmt.Errorf("unknown serialization format %s", format)
+	}
+	return &EnvelopeSerializer[T]{format: format, version: version, writer: writer}, nil
+}
+
+func (s *EnvelopeSerializer[T]) Serialize(value *T) ([]byte, error) {
+	payload, err := s.writer.Serialize(value)
+	if err != nil || s.format == FormatJson {
+		return payload, err
+	}
+	data := make([]byte, 0, 1+binary.MaxVarintLen64+len(payload))
+	data = binary.AppendUvarint(append(data, byte(s.format)), s.version)
+	return append(data, payload...), nil
+}
+
+func (s *EnvelopeSerializer[T]) Deserialize(data []byte) (*T, error) {
+	format, _, payload, err := ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	return newFormatSerializer[T](format).Deserialize(payload)
+}
+
+// ParseEnvelope returns the format, the schema version and the payload of data.
+// Bare json, which has no envelope, is of version zero
+func ParseEnvelope(data []byte) (format Format, version uint64, payload []byte, err error) {
+	if len(data) == 0 {
+		return FormatJson, 0, data, nil
+	}
+	if _, ok := formatNames[Format(data[0])]; !ok {
+		return FormatJson, 0, data, nil
+	}
+	version, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return 0, 0, nil, errMalformedEnvelope
+	}
+	return Format(data[0]), version, data[1+n:], nil
+}
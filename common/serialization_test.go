@@ -0,0 +1,309 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testInner struct {
+	Name string   `json:"name" proto:"1"`
+	Tags []string `json:"tags,omitempty" proto:"2"`
+}
+
+type testRecord struct {
+	Text     string         `json:"text" proto:"1"`
+	Int      int            `json:"int" proto:"2"`
+	Small    int8           `json:"small" proto:"3"`
+	Uint     uint64         `json:"uint" proto:"4"`
+	Float    float64        `json:"float" proto:"5"`
+	Flag     bool           `json:"flag" proto:"6"`
+	Data     []byte         `json:"data" proto:"7"`
+	Strings  []string       `json:"strings" proto:"8"`
+	Numbers  []int32        `json:"numbers" proto:"9"`
+	Inner    testInner      `json:"inner" proto:"10"`
+	Inners   []testInner    `json:"inners" proto:"11"`
+	Optional *testInner     `json:"optional,omitempty" proto:"12"`
+	Labels   map[string]int `json:"labels" proto:"13"`
+	Time     time.Time      `json:"time" proto:"14"`
+	Ignored  string         `json:"-"`
+}
+
+// testRecordV2 is testRecord of a newer version, with a field older readers have to skip
+type testRecordV2 struct {
+	testRecord
+	Extra []testInner `json:"extra" proto:"15"`
+}
+
+var allFormats = []Format{FormatJson, FormatMsgPack, FormatCbor, FormatProtobuf}
+
+func newTestRecord() *testRecord {
+	return &testRecord{
+		Text:     "tool output ✓",
+		Int:      -1 << 40,
+		Small:    -100,
+		Uint:     1<<64 - 1,
+		Float:    3.25,
+		Flag:     true,
+		Data:     []byte{0, 1, 0xff},
+		Strings:  []string{"a.out", ""}, // Missing output files are empty
+		Numbers:  []int32{-1, 0, 300},
+		Inner:    testInner{Name: "inner", Tags: []string{"x"}},
+		Inners:   []testInner{{Name: "first"}, {Name: "second", Tags: []string{"y", "z"}}},
+		Optional: &testInner{},
+		Labels:   map[string]int{"cpu": 2, "zero": 0},
+		Time:     time.Date(2023, 4, 5, 6, 7, 8, 9, time.UTC),
+	}
+}
+
+func newSerializer(t *testing.T, format Format) *EnvelopeSerializer[testRecord] {
+	s, err := NewEnvelopeSerializer[testRecord](format, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSerializersRoundTrip(t *testing.T) {
+	for _, format := range allFormats {
+		s := newSerializer(t, format)
+		for _, record := range []*testRecord{newTestRecord(), {}} {
+			data, err := s.Serialize(record)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			decoded, err := s.Deserialize(data)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if !reflect.DeepEqual(decoded, record) {
+				t.Fatalf("%s: decoded\n%+v\ninstead of\n%+v", format, decoded, record)
+			}
+		}
+	}
+}
+
+func TestEnvelopeReadsAllFormats(t *testing.T) {
+	record := newTestRecord()
+	for _, written := range allFormats {
+		data, err := newSerializer(t, written).Serialize(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		format, version, _, err := ParseEnvelope(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedVersion := uint64(3)
+		if written == FormatJson {
+			expectedVersion = 0 // Written bare for readers which predate the envelope
+			var legacy testRecord
+			if err = json.Unmarshal(data, &legacy); err != nil {
+				t.Fatalf("json isn't readable without the envelope: %v", err)
+			}
+		}
+		if format != written || version != expectedVersion {
+			t.Fatalf("envelope of %s is %s version %d", written, format, version)
+		}
+
+		for _, reader := range allFormats {
+			decoded, err := newSerializer(t, reader).Deserialize(data)
+			if err != nil {
+				t.Fatalf("%s reading %s: %v", reader, written, err)
+			}
+			if !reflect.DeepEqual(decoded, record) {
+				t.Fatalf("%s reading %s: decoded %+v", reader, written, decoded)
+			}
+		}
+	}
+}
+
+func TestEnvelopeReadsLegacyJson(t *testing.T) {
+	decoded, err := newSerializer(t, FormatProtobuf).Deserialize([]byte(` {"text": "old", "numbers": [1], "removed": {"a": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Text != "old" || len(decoded.Numbers) != 1 {
+		t.Fatalf("unexpected %+v", decoded)
+	}
+	if _, err = newSerializer(t, FormatCbor).Deserialize([]byte("not json")); err == nil {
+		t.Fatal("expected an error for garbage")
+	}
+}
+
+func TestUnknownFieldsAreSkipped(t *testing.T) {
+	newer := &testRecordV2{testRecord: *newTestRecord(), Extra: []testInner{{Name: "extra", Tags: []string{"t"}}}}
+	for _, format := range allFormats {
+		writer, err := NewEnvelopeSerializer[testRecordV2](format, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := writer.Serialize(newer)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		decoded, err := newSerializer(t, format).Deserialize(data)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(decoded, newTestRecord()) {
+			t.Fatalf("%s: decoded %+v", format, decoded)
+		}
+	}
+}
+
+func TestBinaryEncodings(t *testing.T) {
+	type small struct {
+		A int      `json:"a" proto:"1"`
+		B []string `json:"b,omitempty" proto:"2"`
+	}
+	tests := []struct {
+		serializer Serializer[small]
+		value      small
+		encoded    string
+	}{
+		{&MsgPackSerializer[small]{}, small{A: 1}, "81a16101"},
+		{&MsgPackSerializer[small]{}, small{A: -33, B: []string{"x"}}, "82a161d0dfa16291a178"},
+		{&CborSerializer[small]{}, small{A: 1}, "a1616101"},
+		{&CborSerializer[small]{}, small{A: -500, B: []string{"x"}}, "a26161" + "3901f3" + "6162" + "81" + "6178"},
+		{&ProtobufSerializer[small]{}, small{A: 150}, "089601"},
+		{&ProtobufSerializer[small]{}, small{B: []string{"", "x"}}, "1200" + "120178"},
+	}
+	for _, test := range tests {
+		data, err := test.serializer.Serialize(&test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(data) != test.encoded {
+			t.Fatalf("%T encoded %+v as %x instead of %s", test.serializer, test.value, data, test.encoded)
+		}
+	}
+
+	// Indefinite lengths, tags and half floats are valid CBOR, even though they're never written
+	type floats struct {
+		A []float64 `json:"a"`
+	}
+	data, _ := hex.DecodeString("bf6161" + "9f" + "f93c00" + "c1" + "01" + "ff" + "ff")
+	decoded, err := (&CborSerializer[floats]{}).Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.A, []float64{1, 1}) {
+		t.Fatalf("decoded %v", decoded.A)
+	}
+	// Unpacked repeated numbers are valid protobuf too
+	type numbers struct {
+		A []int `json:"a" proto:"1"`
+	}
+	data, _ = hex.DecodeString("0801" + "0802")
+	decodedNumbers, err := (&ProtobufSerializer[numbers]{}).Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedNumbers.A, []int{1, 2}) {
+		t.Fatalf("decoded %v", decodedNumbers.A)
+	}
+}
+
+func TestMalformedData(t *testing.T) {
+	for _, format := range []Format{FormatMsgPack, FormatCbor, FormatProtobuf} {
+		s := newSerializer(t, format)
+		data, err := s.Serialize(newTestRecord())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(data); i++ {
+			// Protobuf can't tell a message cut at a field boundary, the others have to fail
+			if _, err = s.Deserialize(data[:i]); err == nil && format != FormatProtobuf {
+				t.Fatalf("%s: truncation to %d bytes went unnoticed", format, i)
+			}
+		}
+		if _, err = s.Deserialize(append(bytes.Clone(data), 0)); err == nil && format != FormatProtobuf {
+			t.Fatalf("%s: trailing data went unnoticed", format)
+		}
+	}
+
+	type wrongType struct {
+		Text int `json:"text" proto:"1"`
+	}
+	for _, format := range allFormats {
+		data, err := newSerializer(t, format).Serialize(newTestRecord())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := NewEnvelopeSerializer[wrongType](format, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = reader.Deserialize(data); err == nil {
+			t.Fatalf("%s: decoded a string into an int", format)
+		}
+	}
+
+	type untagged struct {
+		A int `json:"a"`
+	}
+	if _, err := (&ProtobufSerializer[untagged]{}).Serialize(&untagged{}); err == nil {
+		t.Fatal("serialized a field without a proto tag")
+	}
+}
+
+func TestDeepNestingIsRejected(t *testing.T) {
+	// Unknown fields are skipped whatever their nesting, so only a limit keeps decoders off deep recursion
+	deep := func(field, array, null string) []byte {
+		data, _ := hex.DecodeString(field + strings.Repeat(array, 100_000) + null)
+		return data
+	}
+	if _, err := (&MsgPackSerializer[testRecord]{}).Deserialize(deep("81a178", "91", "c0")); err == nil {
+		t.Fatal("msgpack: decoded 100000 nested arrays")
+	}
+	if _, err := (&CborSerializer[testRecord]{}).Deserialize(deep("a16178", "81", "f6")); err == nil {
+		t.Fatal("cbor: decoded 100000 nested arrays")
+	}
+}
+
+func FuzzDeserialize(f *testing.F) {
+	for _, format := range allFormats {
+		s, err := NewEnvelopeSerializer[testRecord](format, 3)
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, err := s.Serialize(newTestRecord())
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, format := range allFormats {
+			s := newSerializer(t, format)
+			decoded, err := s.Deserialize(data)
+			if err != nil {
+				continue
+			}
+			// Whatever decodes has to survive another round trip
+			if _, err = s.Serialize(decoded); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+		}
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range allFormats {
+		parsed, err := ParseFormat(format.String())
+		if err != nil || parsed != format {
+			t.Fatalf("parsed %s as %s: %v", format, parsed, err)
+		}
+	}
+	if format, err := ParseFormat(""); err != nil || format != FormatJson {
+		t.Fatalf("empty format is %s: %v", format, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatal("parsed an unknown format")
+	}
+}
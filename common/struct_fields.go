@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// structField is an exported field as encoding/json sees it
+type structField struct {
+	index  []int
+	name   string
+	number int // From the proto tag, zero if missing
+}
+
+var structFieldsCache sync.Map // reflect.Type -> []structField
+
+func structFields(t reflect.Type) []structField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && name == "" {
+			// Fields of embedded structs are promoted
+			for _, field := range structFields(f.Type) {
+				field.index = append([]int{i}, field.index...)
+				fields = append(fields, field)
+			}
+			continue
+		}
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field := structField{index: []int{i}, name: name}
+		field.number, _ = strconv.Atoi(f.Tag.Get("proto"))
+		fields = append(fields, field)
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"exec/common"
+	"sync"
+)
+
+// Bus delivers broadcast messages to listeners of matching subjects, without persistence like core nats
+type Bus struct {
+	mu        sync.Mutex
+	listeners map[*busListener]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{listeners: make(map[*busListener]struct{})}
+}
+
+type busListener struct {
+	subject string
+	deliver func(data []byte)
+}
+
+func (b *Bus) publish(subject string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for l := range b.listeners {
+		if subjectMatches(l.subject, subject) {
+			l.deliver(data)
+		}
+	}
+}
+
+type broadcaster[T any] struct {
+	bus        *Bus
+	serializer common.Serializer[T]
+}
+
+func NewBroadcaster[T any](bus *Bus, serializer common.Serializer[T]) common.Broadcaster[T] {
+	return &broadcaster[T]{bus: bus, serializer: serializer}
+}
+
+func (b *broadcaster[T]) Broadcast(subject string, msg *T) error {
+	data, err := b.serializer.Serialize(msg)
+	if err != nil {
+		return err
+	}
+	b.bus.publish(subject, data)
+	return nil
+}
+
+type listener[T any] struct {
+	bus        *Bus
+	serializer common.Serializer[T]
+}
+
+func NewListener[T any](bus *Bus, serializer common.Serializer[T]) common.Listener[T] {
+	return &listener[T]{bus: bus, serializer: serializer}
+}
+
+func (l *listener[T]) Listen(subject string, bufferSize int, ctx context.Context) (<-chan *T, error) {
+	ch := make(chan *T, bufferSize)
+	bl := &busListener{
+		subject: subject,
+		// Called with the bus locked, so it can't race with closing the channel
+		deliver: func(data []byte) {
+			content, err := l.serializer.Deserialize(data)
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- content:
+			default:
+				// Slow reader, drop the message instead of blocking the publisher
+			}
+		},
+	}
+	l.bus.mu.Lock()
+	l.bus.listeners[bl] = struct{}{}
+	l.bus.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		l.bus.mu.Lock()
+		defer l.bus.mu.Unlock()
+		delete(l.bus.listeners, bl)
+		close(ch)
+	}()
+	return ch, nil
+}
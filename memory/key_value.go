@@ -0,0 +1,344 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"log"
+	"sort"
+	"sync"
+)
+
+type kvEntry struct {
+	key      string
+	value    []byte
+	revision uint64
+	op       common.KeyValueOp
+}
+
+func (e *kvEntry) deleted() bool {
+	return e.op != common.KeyValuePut
+}
+
+// KeyValue is a bucket shared by typed views, see NewKeyValueBucket. Like in JetStream revisions are bucket wide,
+// and errors are the ones of nats, so code checking for nats.ErrKeyNotFound works with either
+type KeyValue struct {
+	mu       sync.Mutex
+	revision uint64
+	entries  map[string][]*kvEntry // History of every key oldest first, deletes included
+	watchers map[*kvWatcher]struct{}
+}
+
+func NewKeyValue() *KeyValue {
+	return &KeyValue{
+		entries:  make(map[string][]*kvEntry),
+		watchers: make(map[*kvWatcher]struct{}),
+	}
+}
+
+// latest is nil for keys never stored, kv.mu has to be held
+func (kv *KeyValue) latest(key string) *kvEntry {
+	history := kv.entries[key]
+	if len(history) == 0 {
+		return nil
+	}
+	return history[len(history)-1]
+}
+
+func (kv *KeyValue) get(key string) (*kvEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry := kv.latest(key)
+	if entry == nil || entry.deleted() {
+		return nil, nats.ErrKeyNotFound
+	}
+	return entry, nil
+}
+
+// put stores the entry unless check rejects the current one, which is nil for keys never stored.
+// Purges drop the history of the key
+func (kv *KeyValue) put(key string, value []byte, op common.KeyValueOp, check func(current *kvEntry) error) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if err := check(kv.latest(key)); err != nil {
+		return 0, err
+	}
+	kv.revision++
+	entry := &kvEntry{key: key, value: value, revision: kv.revision, op: op}
+	if op == common.KeyValuePurge {
+		kv.entries[key] = nil
+	}
+	kv.entries[key] = append(kv.entries[key], entry)
+	for w := range kv.watchers {
+		if subjectMatches(w.pattern, key) {
+			w.push(entry)
+		}
+	}
+	return entry.revision, nil
+}
+
+// Delete marks the key deleted, so Get fails with nats.ErrKeyNotFound and Create succeeds again
+func (kv *KeyValue) Delete(key string) error {
+	_, err := kv.put(key, nil, common.KeyValueDelete, func(*kvEntry) error { return nil })
+	return err
+}
+
+func (kv *KeyValue) keys() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var keys []string
+	for key := range kv.entries {
+		if entry := kv.latest(key); entry != nil && !entry.deleted() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (kv *KeyValue) history(key string) []*kvEntry {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return append([]*kvEntry(nil), kv.entries[key]...)
+}
+
+func (kv *KeyValue) watch(pattern string, ctx context.Context) *kvWatcher {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	w := &kvWatcher{pattern: pattern, wake: make(chan struct{}, 1)}
+	var current []*kvEntry
+	for key := range kv.entries {
+		if entry := kv.latest(key); entry != nil && subjectMatches(pattern, key) {
+			current = append(current, entry)
+		}
+	}
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].revision < current[j].revision
+	})
+	for _, entry := range current {
+		w.push(entry)
+	}
+	kv.watchers[w] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+		delete(kv.watchers, w)
+	}()
+	return w
+}
+
+// kvWatcher buffers updates, so slow watchers never block writers
+type kvWatcher struct {
+	pattern string
+	mu      sync.Mutex
+	pending []*kvEntry
+	wake    chan struct{}
+}
+
+func (w *kvWatcher) push(entry *kvEntry) {
+	w.mu.Lock()
+	w.pending = append(w.pending, entry)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *kvWatcher) pop() *kvEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	entry := w.pending[0]
+	w.pending = w.pending[1:]
+	return entry
+}
+
+type kvTypedEntry[T any] struct {
+	key      string
+	value    *T
+	revision uint64
+	op       common.KeyValueOp
+}
+
+func (e *kvTypedEntry[T]) Key() string {
+	return e.key
+}
+
+func (e *kvTypedEntry[T]) Value() *T {
+	return e.value
+}
+
+func (e *kvTypedEntry[T]) Revision() uint64 {
+	return e.revision
+}
+
+func (e *kvTypedEntry[T]) Operation() common.KeyValueOp {
+	return e.op
+}
+
+type keyValueBucket[T any] struct {
+	kv         *KeyValue
+	serializer common.Serializer[T]
+	logger     *log.Logger
+}
+
+func NewKeyValueBucket[T any](kv *KeyValue, serializer common.Serializer[T], opts ...common.KeyValueOpt) common.KeyValueBucket[T] {
+	return &keyValueBucket[T]{kv: kv, serializer: serializer, logger: common.NewKeyValueOptions(opts...).Logger}
+}
+
+func (b *keyValueBucket[T]) typed(entry *kvEntry) (*kvTypedEntry[T], error) {
+	typed := &kvTypedEntry[T]{key: entry.key, revision: entry.revision, op: entry.op}
+	if entry.deleted() {
+		return typed, nil
+	}
+	value, err := b.serializer.Deserialize(entry.value)
+	if err != nil {
+		return nil, err
+	}
+	typed.value = value
+	return typed, nil
+}
+
+func (b *keyValueBucket[T]) Get(key string, _ context.Context) (common.KeyValueEntry[T], error) {
+	entry, err := b.kv.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.typed(entry)
+}
+
+func (b *keyValueBucket[T]) Create(key string, value *T, _ context.Context) (uint64, error) {
+	data, err := b.serializer.Serialize(value)
+	if err != nil {
+		return 0, err
+	}
+	return b.kv.put(key, data, common.KeyValuePut, func(current *kvEntry) error {
+		if current != nil && !current.deleted() {
+			return nats.ErrKeyExists
+		}
+		return nil
+	})
+}
+
+func (b *keyValueBucket[T]) Update(key string, value *T, last uint64, _ context.Context) (uint64, error) {
+	data, err := b.serializer.Serialize(value)
+	if err != nil {
+		return 0, err
+	}
+	return b.kv.put(key, data, common.KeyValuePut, func(current *kvEntry) error {
+		if current == nil && last != 0 || current != nil && current.revision != last {
+			return common.ErrWrongRevNumber
+		}
+		return nil
+	})
+}
+
+func (b *keyValueBucket[T]) CAS(
+	key string,
+	stopPredicate func(*T) (bool, error),
+	alter func(*T) error,
+	ctx context.Context,
+) (*T, uint64, error) {
+	for {
+		entry, err := b.Get(key, ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		stop, err := stopPredicate(entry.Value())
+		if err != nil {
+			return nil, 0, err
+		}
+		if stop {
+			return entry.Value(), entry.Revision(), nil
+		}
+		value := entry.Value()
+		if err = alter(value); err != nil {
+			return nil, 0, err
+		}
+		newRev, err := b.Update(key, value, entry.Revision(), ctx)
+		if errors.Is(err, common.ErrWrongRevNumber) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, newRev, nil
+	}
+}
+
+// Watch accepts the wildcards of nats subjects in key
+func (b *keyValueBucket[T]) Watch(key string, ctx context.Context) (<-chan common.KeyValueEntry[T], error) {
+	w := b.kv.watch(key, ctx)
+	ch := make(chan common.KeyValueEntry[T])
+	go func() {
+		defer close(ch)
+		for {
+			entry := w.pop()
+			if entry == nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-w.wake:
+				}
+				continue
+			}
+			typed, err := b.typed(entry)
+			if err != nil {
+				// Skipped like by the nats bucket
+				b.logger.Printf("Skipping undecodable entry %s of the watch: %v", entry.key, err)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- typed:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (b *keyValueBucket[T]) Keys(context.Context) ([]string, error) {
+	return b.kv.keys(), nil
+}
+
+func (b *keyValueBucket[T]) History(key string, _ context.Context) ([]common.KeyValueEntry[T], error) {
+	entries := b.kv.history(key)
+	if len(entries) == 0 {
+		return nil, nats.ErrKeyNotFound
+	}
+	history := make([]common.KeyValueEntry[T], len(entries))
+	for i, entry := range entries {
+		typed, err := b.typed(entry)
+		if err != nil {
+			return nil, err
+		}
+		history[i] = typed
+	}
+	return history, nil
+}
+
+func (b *keyValueBucket[T]) Delete(key string, last uint64, _ context.Context) error {
+	_, err := b.kv.put(key, nil, common.KeyValueDelete, checkLast(last))
+	return err
+}
+
+func (b *keyValueBucket[T]) Purge(key string, last uint64, _ context.Context) error {
+	_, err := b.kv.put(key, nil, common.KeyValuePurge, checkLast(last))
+	return err
+}
+
+// checkLast accepts any entry if last is zero
+func checkLast(last uint64) func(current *kvEntry) error {
+	return func(current *kvEntry) error {
+		if last != 0 && (current == nil || current.revision != last) {
+			return common.ErrWrongRevNumber
+		}
+		return nil
+	}
+}
@@ -0,0 +1,304 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMsg struct {
+	Value int
+}
+
+func fetchOne(t *testing.T, sub common.PullSubscriber[testMsg], timeout time.Duration) common.Message[testMsg] {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	msgs, err := sub.Fetch(1, ctx)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("fetched %d messages, expected 1", len(msgs))
+	}
+	return msgs[0]
+}
+
+func expectEmpty(t *testing.T, sub common.PullSubscriber[testMsg], timeout time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if msgs, err := sub.Fetch(1, ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected no messages, got %d and error %v", len(msgs), err)
+	}
+}
+
+func TestQueueRedelivery(t *testing.T) {
+	queue := NewQueue("tasks", 100*time.Millisecond, "tasks.>")
+	pub := NewPublisher[testMsg](queue, &common.JsonSerializer[testMsg]{})
+	sub := NewSubscriber[testMsg](queue, &common.JsonSerializer[testMsg]{})
+
+	if err := pub.PublishSync("other", &testMsg{}); !errors.Is(err, ErrNoStream) {
+		t.Fatalf("publishing outside of the stream: %v", err)
+	}
+	if err := pub.PublishSync("tasks.compile", &testMsg{Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := fetchOne(t, sub, time.Second)
+	if msg.Content().Value != 1 {
+		t.Fatalf("unexpected content %v", msg.Content())
+	}
+	expectEmpty(t, sub, 20*time.Millisecond)
+
+	// Nacked messages are delivered again right away
+	if err := msg.NAck(); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Ack(); !errors.Is(err, ErrAlreadyAcked) {
+		t.Fatalf("ack after nack: %v", err)
+	}
+	msg = fetchOne(t, sub, 20*time.Millisecond)
+	if deliveries := msg.(*message[testMsg]).Deliveries(); deliveries != 2 {
+		t.Fatalf("%d deliveries, expected 2", deliveries)
+	}
+
+	// Not acked in time
+	msg = fetchOne(t, sub, time.Second)
+	if deliveries := msg.(*message[testMsg]).Deliveries(); deliveries != 3 {
+		t.Fatalf("%d deliveries, expected 3", deliveries)
+	}
+
+	// Extended while in progress
+	time.Sleep(70 * time.Millisecond)
+	if err := msg.AckInProgress(); err != nil {
+		t.Fatal(err)
+	}
+	expectEmpty(t, sub, 70*time.Millisecond)
+	if err := msg.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Len() != 0 {
+		t.Fatalf("%d messages left after ack", queue.Len())
+	}
+	expectEmpty(t, sub, 150*time.Millisecond)
+}
+
+func TestQueueLateAckOfRedeliveredMessage(t *testing.T) {
+	queue := NewQueue("tasks", 20*time.Millisecond, "tasks")
+	pub := NewPublisher[testMsg](queue, &common.JsonSerializer[testMsg]{})
+	sub := NewSubscriber[testMsg](queue, &common.JsonSerializer[testMsg]{})
+	if err := pub.PublishSync("tasks", &testMsg{}); err != nil {
+		t.Fatal(err)
+	}
+	first := fetchOne(t, sub, time.Second)
+	second := fetchOne(t, sub, time.Second)
+
+	// The stale nack doesn't make the message available while redelivered
+	if err := first.NAck(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.AckInProgress(); err != nil {
+		t.Fatal(err)
+	}
+	expectEmpty(t, sub, 10*time.Millisecond)
+	if err := second.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Len() != 0 {
+		t.Fatalf("%d messages left after ack", queue.Len())
+	}
+}
+
+func TestKeyValueRevisions(t *testing.T) {
+	kv := NewKeyValue()
+	bucket := NewKeyValueBucket[testMsg](kv, &common.JsonSerializer[testMsg]{})
+
+	if _, err := bucket.Get("a", context.Background()); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("get of missing key: %v", err)
+	}
+	rev, err := bucket.Create("a", &testMsg{Value: 1}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.Create("a", &testMsg{Value: 2}, context.Background()); !errors.Is(err, nats.ErrKeyExists) {
+		t.Fatalf("second create: %v", err)
+	}
+	if _, err := bucket.Update("a", &testMsg{Value: 2}, rev+1, context.Background()); !errors.Is(err, common.ErrWrongRevNumber) {
+		t.Fatalf("update of wrong revision: %v", err)
+	}
+	newRev, err := bucket.Update("a", &testMsg{Value: 2}, rev, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRev <= rev {
+		t.Fatalf("revision %d after update of revision %d", newRev, rev)
+	}
+	entry, err := bucket.Get("a", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Value().Value != 2 || entry.Revision() != newRev {
+		t.Fatalf("unexpected entry %v at revision %d", entry.Value(), entry.Revision())
+	}
+
+	// Deleted keys can be created again
+	if err := kv.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.Get("a", context.Background()); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("get of deleted key: %v", err)
+	}
+	if _, err := bucket.Create("a", &testMsg{Value: 3}, context.Background()); err != nil {
+		t.Fatalf("create of deleted key: %v", err)
+	}
+}
+
+func TestKeyValueConcurrentCAS(t *testing.T) {
+	bucket := NewKeyValueBucket[testMsg](NewKeyValue(), &common.JsonSerializer[testMsg]{})
+	if _, err := bucket.Create("counter", &testMsg{}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 20
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			_, _, err := bucket.CAS(
+				"counter",
+				func(*testMsg) (bool, error) { return false, nil },
+				func(msg *testMsg) error { msg.Value++; return nil },
+				context.Background(),
+			)
+			errs <- err
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry, err := bucket.Get("counter", context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Value().Value != writers {
+		t.Fatalf("counter is %d after %d increments", entry.Value().Value, writers)
+	}
+}
+
+func TestKeyValueWatch(t *testing.T) {
+	bucket := NewKeyValueBucket[testMsg](NewKeyValue(), &common.JsonSerializer[testMsg]{})
+	if _, err := bucket.Create("a", &testMsg{Value: 1}, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := bucket.Watch("a", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.Create("b", &testMsg{Value: 10}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.Update("a", &testMsg{Value: 2}, 1, ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []int{1, 2} {
+		select {
+		case entry := <-updates:
+			if entry.Key() != "a" || entry.Value().Value != expected {
+				t.Fatalf("watched %s=%v, expected a=%d", entry.Key(), entry.Value(), expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no update to %d", expected)
+		}
+	}
+	cancel()
+	for range updates {
+	}
+}
+
+func TestObjectStore(t *testing.T) {
+	osb := NewObjectStore("test")
+	if _, err := osb.GetInfo("missing"); !errors.Is(err, nats.ErrObjectNotFound) {
+		t.Fatalf("info of missing object: %v", err)
+	}
+	if _, err := osb.List(); !errors.Is(err, nats.ErrNoObjectsFound) {
+		t.Fatalf("list of empty store: %v", err)
+	}
+	info, err := osb.Put(
+		&nats.ObjectMeta{Name: "b", Headers: nats.Header{"Exec-Class": []string{"source"}}},
+		strings.NewReader("hello"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256 of "hello" in the format of nats
+	if info.Digest != "SHA-256=LPJNul-wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=" {
+		t.Fatalf("digest %s", info.Digest)
+	}
+	if info.Size != 5 || info.Headers.Get("Exec-Class") != "source" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if _, err := osb.PutString("a", "world"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := osb.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(result)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read %q: %v", data, err)
+	}
+	infos, err := osb.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Name != "a" || infos[1].Name != "b" {
+		t.Fatalf("unexpected list %v", infos)
+	}
+
+	if err := osb.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := osb.Get("b"); !errors.Is(err, nats.ErrObjectNotFound) {
+		t.Fatalf("get of deleted object: %v", err)
+	}
+	if infos, _ := osb.List(); len(infos) != 1 {
+		t.Fatalf("%d objects listed after delete", len(infos))
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	broadcaster := NewBroadcaster[testMsg](bus, &common.JsonSerializer[testMsg]{})
+	listener := NewListener[testMsg](bus, &common.JsonSerializer[testMsg]{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := listener.Listen("events.*", 1, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := broadcaster.Broadcast("events.task", &testMsg{Value: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := broadcaster.Broadcast("other", &testMsg{}); err != nil {
+		t.Fatal(err)
+	}
+	// The buffer holds the first message, the rest is dropped
+	if msg := <-ch; msg.Value != 1 {
+		t.Fatalf("received %d, expected 1", msg.Value)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("received after cancelling")
+	}
+}
@@ -0,0 +1,275 @@
+package memory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotSupported is returned for links and watches, which nothing here uses
+var ErrNotSupported = errors.New("not supported by the in-memory object store")
+
+var errSealed = errors.New("object store is sealed")
+
+// chunkSize of nats object stores, only used to report chunk counts
+const chunkSize = 128 * 1024
+
+var _ nats.ObjectStore = (*ObjectStore)(nil)
+
+type storedObject struct {
+	info nats.ObjectInfo
+	data []byte
+}
+
+// ObjectStore implements nats.ObjectStore with the same errors, digests and metadata. Options are ignored
+type ObjectStore struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]*storedObject // Deleted objects included
+	sealed  bool
+}
+
+func NewObjectStore(bucket string) *ObjectStore {
+	return &ObjectStore{bucket: bucket, objects: make(map[string]*storedObject)}
+}
+
+func copyInfo(info *nats.ObjectInfo) *nats.ObjectInfo {
+	c := *info
+	if info.Headers != nil {
+		c.Headers = make(nats.Header, len(info.Headers))
+		for key, values := range info.Headers {
+			c.Headers[key] = append([]string{}, values...)
+		}
+	}
+	return &c
+}
+
+func (s *ObjectStore) Put(meta *nats.ObjectMeta, reader io.Reader, _ ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	if meta == nil || meta.Name == "" {
+		return nil, nats.ErrBadObjectMeta
+	}
+	if reader == nil {
+		return nil, nats.ErrObjectRequired
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	hash.Write(data)
+	info := copyInfo(&nats.ObjectInfo{
+		ObjectMeta: *meta,
+		Bucket:     s.bucket,
+		NUID:       common.GetRandomId(),
+		Size:       uint64(len(data)),
+		ModTime:    time.Now().UTC(),
+		Chunks:     uint32((len(data) + chunkSize - 1) / chunkSize),
+		Digest:     nats.GetObjectDigestValue(hash),
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return nil, errSealed
+	}
+	s.objects[meta.Name] = &storedObject{info: *info, data: data}
+	return copyInfo(info), nil
+}
+
+func (s *ObjectStore) object(name string) (*storedObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[name]
+	if !ok || object.info.Deleted {
+		return nil, nats.ErrObjectNotFound
+	}
+	return object, nil
+}
+
+type objectResult struct {
+	*bytes.Reader
+	info *nats.ObjectInfo
+}
+
+func (r *objectResult) Info() (*nats.ObjectInfo, error) {
+	return copyInfo(r.info), nil
+}
+
+func (r *objectResult) Error() error {
+	return nil
+}
+
+func (r *objectResult) Close() error {
+	return nil
+}
+
+func (s *ObjectStore) Get(name string, _ ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+	object, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+	return &objectResult{Reader: bytes.NewReader(object.data), info: &object.info}, nil
+}
+
+func (s *ObjectStore) PutBytes(name string, data []byte, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return s.Put(&nats.ObjectMeta{Name: name}, bytes.NewReader(data), opts...)
+}
+
+func (s *ObjectStore) GetBytes(name string, _ ...nats.GetObjectOpt) ([]byte, error) {
+	object, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, object.data...), nil
+}
+
+func (s *ObjectStore) PutString(name string, data string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return s.PutBytes(name, []byte(data), opts...)
+}
+
+func (s *ObjectStore) GetString(name string, opts ...nats.GetObjectOpt) (string, error) {
+	data, err := s.GetBytes(name, opts...)
+	return string(data), err
+}
+
+// PutFile names the object by the path of the file, like nats does
+func (s *ObjectStore) PutFile(file string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return s.Put(&nats.ObjectMeta{Name: file}, f, opts...)
+}
+
+func (s *ObjectStore) GetFile(name string, file string, opts ...nats.GetObjectOpt) error {
+	data, err := s.GetBytes(name, opts...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+func (s *ObjectStore) GetInfo(name string, _ ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+	object, err := s.object(name)
+	if err != nil {
+		return nil, err
+	}
+	return copyInfo(&object.info), nil
+}
+
+func (s *ObjectStore) UpdateMeta(name string, meta *nats.ObjectMeta) error {
+	if meta == nil || meta.Name == "" {
+		return nats.ErrBadObjectMeta
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return errSealed
+	}
+	object, ok := s.objects[name]
+	if !ok || object.info.Deleted {
+		return nats.ErrUpdateMetaDeleted
+	}
+	if existing, ok := s.objects[meta.Name]; ok && name != meta.Name && !existing.info.Deleted {
+		return nats.ErrObjectAlreadyExists
+	}
+	updated := &storedObject{info: object.info, data: object.data}
+	updated.info.Name = meta.Name
+	updated.info.Description = meta.Description
+	updated.info.Headers = copyInfo(&nats.ObjectInfo{ObjectMeta: *meta}).Headers
+	delete(s.objects, name)
+	s.objects[meta.Name] = updated
+	return nil
+}
+
+func (s *ObjectStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return errSealed
+	}
+	object, ok := s.objects[name]
+	if !ok {
+		return nats.ErrObjectNotFound
+	}
+	info := object.info
+	info.Deleted = true
+	info.Size = 0
+	info.Chunks = 0
+	info.Digest = ""
+	info.ModTime = time.Now().UTC()
+	s.objects[name] = &storedObject{info: info}
+	return nil
+}
+
+func (s *ObjectStore) AddLink(string, *nats.ObjectInfo) (*nats.ObjectInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ObjectStore) AddBucketLink(string, nats.ObjectStore) (*nats.ObjectInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ObjectStore) Seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = true
+	return nil
+}
+
+func (s *ObjectStore) Watch(...nats.WatchOpt) (nats.ObjectWatcher, error) {
+	return nil, ErrNotSupported
+}
+
+// List returns objects sorted by name
+func (s *ObjectStore) List(...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var infos []*nats.ObjectInfo
+	for _, object := range s.objects {
+		if !object.info.Deleted {
+			infos = append(infos, copyInfo(&object.info))
+		}
+	}
+	if len(infos) == 0 {
+		return nil, nats.ErrNoObjectsFound
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+type objectStoreStatus struct {
+	bucket string
+	sealed bool
+	size   uint64
+}
+
+func (s *objectStoreStatus) Bucket() string            { return s.bucket }
+func (s *objectStoreStatus) Description() string       { return "" }
+func (s *objectStoreStatus) TTL() time.Duration        { return 0 }
+func (s *objectStoreStatus) Storage() nats.StorageType { return nats.MemoryStorage }
+func (s *objectStoreStatus) Replicas() int             { return 1 }
+func (s *objectStoreStatus) Sealed() bool              { return s.sealed }
+func (s *objectStoreStatus) Size() uint64              { return s.size }
+func (s *objectStoreStatus) BackingStore() string      { return "memory" }
+
+func (s *ObjectStore) Status() (nats.ObjectStoreStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := &objectStoreStatus{bucket: s.bucket, sealed: s.sealed}
+	for _, object := range s.objects {
+		status.size += object.info.Size
+	}
+	return status, nil
+}
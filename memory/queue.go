@@ -0,0 +1,272 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// duplicateWindow in which messages with the msg id of an earlier one are dropped, the default of JetStream streams
+const duplicateWindow = 2 * time.Minute
+
+var (
+	// ErrAlreadyAcked is returned when a delivery is acked, nacked or marked in progress after it was acked or nacked
+	ErrAlreadyAcked = errors.New("message was already acknowledged")
+
+	// ErrNoStream is returned by PublishSync to subjects the queue doesn't accept, like publishing to a subject
+	// without stream
+	ErrNoStream = errors.New("no stream accepts the subject")
+
+	// ErrWrongStream is returned by PublishSync if the expected stream isn't the queue
+	ErrWrongStream = errors.New("expected stream does not match")
+)
+
+type queuedMsg struct {
+	subject    string
+	data       []byte
+	headers    http.Header
+	deliveries int
+	deadline   time.Time // Of the ack of the last delivery, zero while waiting for delivery
+}
+
+// Queue is a work queue stream with a single pull consumer, like the tasks stream and the consumer of workers.
+// Messages are removed once acked, and delivered again once nacked or not acked in time
+type Queue struct {
+	name     string
+	subjects []string
+	ackWait  time.Duration
+
+	mu       sync.Mutex
+	messages []*queuedMsg // In publishing order
+	msgIds   map[string]time.Time
+	changed  signal
+}
+
+// NewQueue accepts messages published to subjects matching any of the patterns, name is the stream
+// publishers may expect
+func NewQueue(name string, ackWait time.Duration, subjects ...string) *Queue {
+	return &Queue{name: name, subjects: subjects, ackWait: ackWait, msgIds: make(map[string]time.Time)}
+}
+
+// Len counts messages not acked yet, including the ones being processed
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages)
+}
+
+func (q *Queue) publish(subject string, data []byte, options *common.PublishOptions) error {
+	accepted := false
+	for _, pattern := range q.subjects {
+		accepted = accepted || subjectMatches(pattern, subject)
+	}
+	if !accepted {
+		return ErrNoStream
+	}
+	if options.ExpectedStream != "" && options.ExpectedStream != q.name {
+		return ErrWrongStream
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for id, published := range q.msgIds {
+		if published.Add(duplicateWindow).Before(now) {
+			delete(q.msgIds, id)
+		}
+	}
+	if options.MsgId != "" {
+		if _, ok := q.msgIds[options.MsgId]; ok {
+			return nil
+		}
+		q.msgIds[options.MsgId] = now
+	}
+	q.messages = append(q.messages, &queuedMsg{subject: subject, data: data, headers: options.Headers.Clone()})
+	q.changed.broadcast()
+	return nil
+}
+
+// fetch waits for up to n messages until ctx is done
+func (q *Queue) fetch(n int, ctx context.Context) ([]*delivery, error) {
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var deliveries []*delivery
+		var nextDeadline time.Time
+		for _, msg := range q.messages {
+			if !msg.deadline.IsZero() && msg.deadline.After(now) {
+				if nextDeadline.IsZero() || msg.deadline.Before(nextDeadline) {
+					nextDeadline = msg.deadline
+				}
+				continue
+			}
+			if len(deliveries) == n {
+				continue
+			}
+			msg.deliveries++
+			msg.deadline = now.Add(q.ackWait)
+			deliveries = append(deliveries, &delivery{queue: q, msg: msg, number: msg.deliveries})
+		}
+		changed := q.changed.wait()
+		q.mu.Unlock()
+		if len(deliveries) != 0 {
+			return deliveries, nil
+		}
+
+		if err := waitForChange(ctx, changed, nextDeadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// delivery of a message, acknowledgements of earlier deliveries are accepted as long as the message is queued
+type delivery struct {
+	queue  *Queue
+	msg    *queuedMsg
+	number int
+	done   bool
+}
+
+func (d *delivery) ack() error {
+	q := d.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d.done {
+		return ErrAlreadyAcked
+	}
+	d.done = true
+	for i, msg := range q.messages {
+		if msg == d.msg {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (d *delivery) nack() error {
+	q := d.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d.done {
+		return ErrAlreadyAcked
+	}
+	d.done = true
+	if d.msg.deliveries == d.number {
+		d.msg.deadline = time.Time{}
+		q.changed.broadcast()
+	}
+	return nil
+}
+
+func (d *delivery) inProgress() error {
+	q := d.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if d.done {
+		return ErrAlreadyAcked
+	}
+	if d.msg.deliveries == d.number {
+		d.msg.deadline = time.Now().Add(q.ackWait)
+	}
+	return nil
+}
+
+type message[T any] struct {
+	delivery *delivery
+	content  *T
+}
+
+func (m *message[T]) Content() *T {
+	return m.content
+}
+
+// Headers have canonical keys like the ones of the nats subscriber
+func (m *message[T]) Headers() http.Header {
+	headers := make(http.Header, len(m.delivery.msg.headers))
+	for key, values := range m.delivery.msg.headers {
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+	return headers
+}
+
+func (m *message[T]) Ack() error {
+	return m.delivery.ack()
+}
+
+func (m *message[T]) NAck() error {
+	return m.delivery.nack()
+}
+
+func (m *message[T]) AckInProgress() error {
+	return m.delivery.inProgress()
+}
+
+// Deliveries counts deliveries of the message including this one, like the NumDelivered of nats metadata
+func (m *message[T]) Deliveries() int {
+	return m.delivery.number
+}
+
+type publisher[T any] struct {
+	queue      *Queue
+	serializer common.Serializer[T]
+}
+
+func NewPublisher[T any](queue *Queue, serializer common.Serializer[T]) common.Publisher[T] {
+	return &publisher[T]{queue: queue, serializer: serializer}
+}
+
+func (p *publisher[T]) PublishSync(subject string, msg *T, opts ...common.PublishOpt) error {
+	data, err := p.serializer.Serialize(msg)
+	if err != nil {
+		return err
+	}
+	return p.queue.publish(subject, data, common.NewPublishOptions(opts...))
+}
+
+type subscriber[T any] struct {
+	queue      *Queue
+	serializer common.Serializer[T]
+}
+
+func NewSubscriber[T any](queue *Queue, serializer common.Serializer[T]) common.PullSubscriber[T] {
+	return &subscriber[T]{queue: queue, serializer: serializer}
+}
+
+// Fetch returns once at least one message is available, or with the error of ctx once it's done
+func (s *subscriber[T]) Fetch(n int, ctx context.Context) ([]common.Message[T], error) {
+	deliveries, err := s.queue.fetch(n, ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]common.Message[T], len(deliveries))
+	for i, d := range deliveries {
+		content, err := s.serializer.Deserialize(d.msg.data)
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = &message[T]{delivery: d, content: content}
+	}
+	return msgs, nil
+}
+
+// waitForChange returns once changed is closed, the deadline if any passes or ctx is done
+func waitForChange(ctx context.Context, changed <-chan struct{}, deadline time.Time) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-changed:
+	case <-expired:
+	}
+	return nil
+}
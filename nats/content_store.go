@@ -0,0 +1,270 @@
+package nats
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	contentAddressPrefix = "sha256-"
+	digestHexLength      = 2 * sha256.Size
+
+	// RefsKeyPrefix of the key value entries tracking references of content addressed objects
+	RefsKeyPrefix = "refs."
+
+	// deletionTimeout after which an interrupted deletion is taken over by the next upload
+	deletionTimeout      = 10 * time.Minute
+	deletionPollInterval = 100 * time.Millisecond
+)
+
+// ErrObjectReferenced is returned by ContentStore.Delete when the object was referenced meanwhile
+var ErrObjectReferenced = errors.New("object is still referenced")
+
+// ObjectRefs tracks references of a content addressed object. References are never released, every upload
+// of the content takes one even if the upload itself is skipped and extends the lifetime of the object.
+// An object is unreferenced once the retention of its class has passed since LastReferenced
+type ObjectRefs struct {
+	LastReferenced time.Time `json:"last-referenced"`
+	DeletingSince  time.Time `json:"deleting-since"` // Zero unless being deleted
+}
+
+// ContentStore names objects by the SHA-256 digest of their content and skips uploads of content already stored.
+// References live in a key value bucket, so deletions can't race with uploads reusing the object. Puts check
+// the references again afterwards and start over if a deletion began meanwhile. The one race left is a deletion
+// that stalls for longer than deletionTimeout: an upload takes it over and reuses the object, which the stalled
+// deletion may still remove once it resumes. Deletions take far less than the timeout
+
+type ContentStore struct {
+	store common.ObjectStore
+	refs  common.KeyValueBucket[ObjectRefs]
+}
+
+func NewContentStore(store common.ObjectStore, kv nats.KeyValue, policy *RetryPolicy) *ContentStore {
+	return &ContentStore{
+		store: store,
+		refs:  NewKeyValueTypedWrapper[ObjectRefs](kv, &common.JsonSerializer[ObjectRefs]{}, policy),
+	}
+}
+
+// ContentAddress is the object name of content with the digest, followed by the scope if there is one.
+// Content is deduplicated only within a scope, e.g. so owners can't probe each other's uploads
+func ContentAddress(scope string, digest []byte) string {
+	name := contentAddressPrefix + hex.EncodeToString(digest)
+	if scope == "" {
+		return name
+	}
+	return name + "-" + scope
+}
+
+func IsContentAddressed(name string) bool {
+	rest, ok := strings.CutPrefix(name, contentAddressPrefix)
+	if !ok || len(rest) < digestHexLength {
+		return false
+	}
+	if _, err := hex.DecodeString(rest[:digestHexLength]); err != nil {
+		return false
+	}
+	return len(rest) == digestHexLength || rest[digestHexLength] == '-'
+}
+
+func RefsKey(name string) string {
+	return RefsKeyPrefix + name
+}
+
+// Put stores data unless it's already stored, meta.Name is replaced by the content address.
+// The second result reports whether the data was actually uploaded. Seekable data is read twice,
+// anything else is spooled to a temporary file, so the content is never loaded to memory
+func (s *ContentStore) Put(ctx context.Context, data io.Reader, scope string, meta *common.ObjectMeta) (*common.ObjectInfo, bool, error) {
+	seeker, ok := data.(io.ReadSeeker)
+	if !ok {
+		return s.putSpooled(ctx, data, scope, meta)
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false, err
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, seeker); err != nil {
+		return nil, false, err
+	}
+	return s.put(ctx, ContentAddress(scope, hash.Sum(nil)), meta, func(meta *common.ObjectMeta) (*common.ObjectInfo, error) {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return s.store.Put(meta, seeker, ctx)
+	})
+}
+
+func (s *ContentStore) putSpooled(ctx context.Context, data io.Reader, scope string, meta *common.ObjectMeta) (*common.ObjectInfo, bool, error) {
+	file, err := os.CreateTemp("", "exec-content-*")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return s.PutFile(ctx, file.Name(), scope, meta)
+}
+
+// PutFile is Put for files, which aren't loaded to memory
+func (s *ContentStore) PutFile(ctx context.Context, filePath string, scope string, meta *common.ObjectMeta) (*common.ObjectInfo, bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	_ = file.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	return s.put(ctx, ContentAddress(scope, hash.Sum(nil)), meta, func(meta *common.ObjectMeta) (*common.ObjectInfo, error) {
+		return common.PutObjectFile(s.store, meta, filePath, ctx)
+	})
+}
+
+func (s *ContentStore) put(
+	ctx context.Context,
+	name string,
+	meta *common.ObjectMeta,
+	upload func(*common.ObjectMeta) (*common.ObjectInfo, error),
+) (*common.ObjectInfo, bool, error) {
+	var uploaded bool
+	for {
+		// Referenced before checking the object, so it can't be deleted after the check
+		if err := s.reference(ctx, name); err != nil {
+			return nil, false, err
+		}
+		info, err := s.store.GetInfo(name, ctx)
+		if errors.Is(err, common.ErrObjectNotFound) {
+			named := *meta
+			named.Name = name
+			info, err = upload(&named)
+			uploaded = uploaded || err == nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		// A deletion that got past the reference, e.g. because of skewed clocks, may have removed the object
+		deleting, err := s.deleting(ctx, name)
+		if err != nil {
+			return nil, false, err
+		}
+		if !deleting {
+			return info, uploaded, nil
+		}
+	}
+}
+
+// deleting reports whether a deletion of the object started or even finished
+func (s *ContentStore) deleting(ctx context.Context, name string) (bool, error) {
+	entry, err := s.refs.Get(RefsKey(name), ctx)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !entry.Value().DeletingSince.IsZero(), nil
+}
+
+// reference takes a reference, waiting for a deletion in progress to finish
+func (s *ContentStore) reference(ctx context.Context, name string) error {
+	key := RefsKey(name)
+	for {
+		entry, err := s.refs.Get(key, ctx)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			_, err = s.refs.Create(key, &ObjectRefs{LastReferenced: time.Now()}, ctx)
+			if errors.Is(err, nats.ErrKeyExists) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		refs := entry.Value()
+		now := time.Now()
+		if !refs.DeletingSince.IsZero() && now.Sub(refs.DeletingSince) < deletionTimeout {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(deletionPollInterval):
+			}
+			continue
+		}
+		// Deletions interrupted for too long are taken over, the object is uploaded again if it's already gone
+		refs.LastReferenced = now
+		refs.DeletingSince = time.Time{}
+		_, err = s.refs.Update(key, refs, entry.Revision(), ctx)
+		if errors.Is(err, common.ErrWrongRevNumber) {
+			continue
+		}
+		return err
+	}
+}
+
+// Refs returns the references of a content addressed object
+func (s *ContentStore) Refs(ctx context.Context, name string) (*ObjectRefs, error) {
+	entry, err := s.refs.Get(RefsKey(name), ctx)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value(), nil
+}
+
+// Delete removes the object if expired holds for its references, otherwise returns ErrObjectReferenced.
+// Uploads of the same content wait until it's done
+func (s *ContentStore) Delete(ctx context.Context, name string, expired func(*ObjectRefs) bool) error {
+	key := RefsKey(name)
+	entry, err := s.refs.Get(key, ctx)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return s.deleteObject(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+	refs := entry.Value()
+	// Deletions interrupted before are resumed
+	if refs.DeletingSince.IsZero() && !expired(refs) {
+		return ErrObjectReferenced
+	}
+	refs.DeletingSince = time.Now()
+	rev, err := s.refs.Update(key, refs, entry.Revision(), ctx)
+	if errors.Is(err, common.ErrWrongRevNumber) {
+		return ErrObjectReferenced
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.deleteObject(ctx, name); err != nil {
+		return err
+	}
+	err = s.refs.Purge(key, rev, ctx)
+	if errors.Is(err, common.ErrWrongRevNumber) {
+		// The deletion was taken over, the object is uploaded again
+		return ErrObjectReferenced
+	}
+	return err
+}
+
+func (s *ContentStore) deleteObject(ctx context.Context, name string) error {
+	err := s.store.Delete(name, ctx)
+	if errors.Is(err, common.ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package nats
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"exec/common"
+	"exec/memory"
+	"exec/nats/natstest"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"testing"
+)
+
+func TestIsContentAddressed(t *testing.T) {
+	digest := sha256.Sum256([]byte("content"))
+	for name, expected := range map[string]bool{
+		ContentAddress("", digest[:]):                    true,
+		ContentAddress("scope", digest[:]):               true,
+		ContentAddress("scope-binary", digest[:]):        true,
+		"sha256-" + strings.Repeat("0", 63):              false,
+		"sha256-" + strings.Repeat("g", 64):              false,
+		"sha256-" + strings.Repeat("0", 65):              false,
+		"alice-sha256-" + strings.Repeat("0", 64):        false,
+		"user-sha256-fan":                                false,
+		"4f2a9c3e1b7d8e60sha256-" + common.GetRandomId(): false,
+	} {
+		if IsContentAddressed(name) != expected {
+			t.Errorf("IsContentAddressed(%q) isn't %v", name, expected)
+		}
+	}
+}
+
+// racingObjectStore deletes the object right after its info was checked by the first put
+type racingObjectStore struct {
+	common.ObjectStore
+	contents *ContentStore
+	raced    bool
+}
+
+func (s *racingObjectStore) GetInfo(name string, ctx context.Context) (*common.ObjectInfo, error) {
+	info, err := s.ObjectStore.GetInfo(name, ctx)
+	if err == nil && !s.raced {
+		s.raced = true
+		if err := s.contents.Delete(ctx, name, func(*ObjectRefs) bool { return true }); err != nil {
+			return nil, err
+		}
+	}
+	return info, err
+}
+
+func TestContentStorePutsAgainAfterRacingDeletion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a nats server")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "refs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store := &racingObjectStore{ObjectStore: NewObjectStoreWrapper(memory.NewObjectStore("test"), DefaultRetryPolicy())}
+	store.contents = NewContentStore(store, kv, DefaultRetryPolicy())
+	if _, _, err := store.contents.Put(ctx, strings.NewReader("content"), "", &common.ObjectMeta{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, uploaded, err := store.contents.Put(ctx, strings.NewReader("content"), "", &common.ObjectMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.raced || !uploaded {
+		t.Fatal("the object wasn't uploaded again after the deletion")
+	}
+	if _, err = store.ObjectStore.GetInfo(info.Name, ctx); errors.Is(err, common.ErrObjectNotFound) {
+		t.Fatal("the object was lost")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
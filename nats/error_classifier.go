@@ -0,0 +1,60 @@
+package nats
+
+import (
+	"errors"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrorClassifier reports whether err is transient, i.e. whether the operation failing with it may succeed if retried
+type ErrorClassifier func(err error) bool
+
+// Or considers errors transient if either of the classifiers does, e.g. to retry errors specific to a deployment
+func (c ErrorClassifier) Or(other ErrorClassifier) ErrorClassifier {
+	return func(err error) bool {
+		return c(err) || other(err)
+	}
+}
+
+// JetStream API error codes returned while a cluster elects leaders or moves streams, nats.go has no constants for them
+const (
+	jsErrCodeClusterNotAssigned nats.ErrorCode = 10007
+	jsErrCodeClusterNotAvail    nats.ErrorCode = 10008
+	jsErrCodeClusterNotLeader   nats.ErrorCode = 10009
+	jsErrCodeStreamOffline      nats.ErrorCode = 10118
+	jsErrCodeConsumerOffline    nats.ErrorCode = 10119
+)
+
+var transientErrors = []error{
+	nats.ErrTimeout,
+	nats.ErrConnectionReconnecting,
+	nats.ErrDisconnected,
+	nats.ErrStaleConnection,
+	nats.ErrNoServers,
+	nats.ErrNoResponders,
+	nats.ErrNoStreamResponse, // Publishing to a stream without leader
+	nats.ErrConsumerLeadershipChanged,
+	nats.ErrDigestMismatch, // Object chunks read partially during a failover
+}
+
+var transientErrorCodes = map[nats.ErrorCode]bool{
+	jsErrCodeClusterNotAssigned: true,
+	jsErrCodeClusterNotAvail:    true,
+	jsErrCodeClusterNotLeader:   true,
+	jsErrCodeStreamOffline:      true,
+	jsErrCodeConsumerOffline:    true,
+}
+
+// IsTransientError is the default ErrorClassifier. It recognizes errors of reconnections and of cluster failovers,
+// anything else, like missing keys, revision mismatches and servers without JetStream, is permanent
+func IsTransientError(err error) bool {
+	for _, e := range transientErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	var apiErr *nats.APIError
+	if errors.As(err, &apiErr) {
+		return transientErrorCodes[apiErr.ErrorCode]
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nats.ErrTimeout, true},
+		{nats.ErrConnectionReconnecting, true},
+		{nats.ErrNoResponders, true},
+		{nats.ErrNoStreamResponse, true},
+		{nats.ErrConsumerLeadershipChanged, true},
+		{fmt.Errorf("putting chunk: %w", nats.ErrTimeout), true},
+		{&nats.APIError{Code: 503, ErrorCode: jsErrCodeClusterNotAvail, Description: "JetStream system temporarily unavailable"}, true},
+		{&nats.APIError{Code: 500, ErrorCode: jsErrCodeClusterNotLeader, Description: "JetStream cluster can not handle request"}, true},
+		{fmt.Errorf("wrapped: %w", &nats.APIError{Code: 500, ErrorCode: jsErrCodeStreamOffline}), true},
+		{nats.ErrKeyNotFound, false},
+		{nats.ErrKeyExists, false},
+		{nats.ErrObjectNotFound, false},
+		{nats.ErrStreamNotFound, false},
+		{nats.ErrConnectionClosed, false},
+		{nats.ErrJetStreamNotEnabled, false}, // Misconfigured servers don't get better by retrying
+		{&nats.APIError{Code: 400, ErrorCode: nats.JSErrCodeStreamWrongLastSequence}, false},
+		{io.ErrUnexpectedEOF, false},
+	}
+	for _, test := range tests {
+		if got := IsTransientError(test.err); got != test.transient {
+			t.Errorf("IsTransientError(%v) = %v, want %v", test.err, got, test.transient)
+		}
+	}
+}
+
+func TestErrorClassifierOr(t *testing.T) {
+	custom := errors.New("custom")
+	classifier := ErrorClassifier(IsTransientError).Or(func(err error) bool {
+		return errors.Is(err, custom)
+	})
+	if !classifier(custom) || !classifier(nats.ErrTimeout) {
+		t.Error("errors of either classifier should be transient")
+	}
+	if classifier(nats.ErrKeyNotFound) {
+		t.Error("errors of neither classifier should be permanent")
+	}
+}
+
+func TestRetryStopsAtPermanentError(t *testing.T) {
+	errs := []error{nats.ErrTimeout, nats.ErrNoResponders, nats.ErrKeyExists, nil}
+	var retried []error
+	policy := &RetryPolicy{
+		InitialInterval: time.Millisecond,
+		OnRetry: func(operation string, attempt int, err error, delay time.Duration) {
+			retried = append(retried, err)
+		},
+	}
+	attempts := 0
+	err := policy.retry(context.Background(), "test", func() error {
+		err := errs[attempts]
+		attempts++
+		return err
+	})
+	if !errors.Is(err, nats.ErrKeyExists) {
+		t.Fatalf("got %v, want %v", err, nats.ErrKeyExists)
+	}
+	if attempts != 3 || len(retried) != 2 {
+		t.Fatalf("got %d attempts and %d retries, want 3 and 2", attempts, len(retried))
+	}
+}
+
+func TestRetryUsesClassifier(t *testing.T) {
+	attempts := 0
+	policy := &RetryPolicy{
+		InitialInterval: time.Millisecond,
+		Classifier: func(err error) bool {
+			return false
+		},
+	}
+	err := policy.retry(context.Background(), "test", func() error {
+		attempts++
+		return nats.ErrTimeout
+	})
+	if !errors.Is(err, nats.ErrTimeout) || attempts != 1 {
+		t.Fatalf("got %v after %d attempts, want %v after 1", err, attempts, nats.ErrTimeout)
+	}
+}
+
+func TestRetryIsBounded(t *testing.T) {
+	attempts := 0
+	policy := &RetryPolicy{MaxAttempts: 4, InitialInterval: time.Millisecond}
+	err := policy.retry(context.Background(), "test", func() error {
+		attempts++
+		return nats.ErrTimeout
+	})
+	if !errors.Is(err, nats.ErrTimeout) || attempts != 4 {
+		t.Fatalf("got %v after %d attempts, want %v after 4", err, attempts, nats.ErrTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	policy = &RetryPolicy{InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond}
+	err = policy.retry(ctx, "test", func() error {
+		return nats.ErrTimeout
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("got %v, want both the context and the last error", err)
+	}
+}
+
+// hungKeyValue never answers gets, like a server that stopped responding mid-request
+type hungKeyValue struct {
+	nats.KeyValue
+	release chan struct{}
+}
+
+func (kv *hungKeyValue) Get(string) (nats.KeyValueEntry, error) {
+	<-kv.release
+	return nil, nats.ErrTimeout
+}
+
+func TestRetryAttemptsEndWithContext(t *testing.T) {
+	kv := &hungKeyValue{release: make(chan struct{})}
+	defer close(kv.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := RobustGetKVEntry(ctx, DefaultRetryPolicy(), kv, "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hung attempt outlived the context by %v", elapsed)
+	}
+}
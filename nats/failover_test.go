@@ -0,0 +1,199 @@
+package nats
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startCluster runs a JetStream cluster of embedded servers and waits until it's ready for replicated streams
+func startCluster(t *testing.T, size int) []*server.Server {
+	clusterPorts := make([]int, size)
+	routes := make([]string, size)
+	for i := range clusterPorts {
+		clusterPorts[i] = freePort(t)
+		routes[i] = fmt.Sprintf("nats://127.0.0.1:%d", clusterPorts[i])
+	}
+	servers := make([]*server.Server, size)
+	for i := range servers {
+		s, err := server.NewServer(&server.Options{
+			ServerName: fmt.Sprintf("s%d", i),
+			Host:       "127.0.0.1",
+			Port:       -1,
+			JetStream:  true,
+			StoreDir:   t.TempDir(),
+			Cluster: server.ClusterOpts{
+				Name: "exec",
+				Host: "127.0.0.1",
+				Port: clusterPorts[i],
+			},
+			Routes: server.RoutesFromStr(strings.Join(routes, ",")),
+			NoLog:  true,
+			NoSigs: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Start()
+		servers[i] = s
+		t.Cleanup(s.Shutdown)
+	}
+	for _, s := range servers {
+		if !s.ReadyForConnections(10 * time.Second) {
+			t.Fatalf("server %s didn't start", s.Name())
+		}
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for !hasAllPeers(servers) {
+		if time.Now().After(deadline) {
+			t.Fatal("JetStream cluster didn't elect a leader")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return servers
+}
+
+// hasAllPeers once the meta leader knows every server, otherwise replicated streams can't be placed yet
+func hasAllPeers(servers []*server.Server) bool {
+	for _, s := range servers {
+		if s.JetStreamIsLeader() {
+			return len(s.JetStreamClusterPeers()) == len(servers)
+		}
+	}
+	return false
+}
+
+func connectCluster(t *testing.T, servers []*server.Server) nats.JetStreamContext {
+	urls := make([]string, len(servers))
+	for i, s := range servers {
+		urls[i] = s.ClientURL()
+	}
+	nc, err := nats.Connect(strings.Join(urls, ","), nats.MaxReconnects(-1), nats.ReconnectWait(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream(nats.MaxWait(2 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+// killStreamLeader shuts down the server leading the stream
+func killStreamLeader(t *testing.T, js nats.JetStreamContext, servers []*server.Server, stream string) {
+	info, err := js.StreamInfo(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		if s.Name() == info.Cluster.Leader {
+			s.Shutdown()
+			return
+		}
+	}
+	t.Fatalf("leader %q of %s not found", info.Cluster.Leader, stream)
+}
+
+// failoverPolicy records retried errors, which all must be transient for the operations to succeed
+func failoverPolicy(t *testing.T) *RetryPolicy {
+	var mu sync.Mutex
+	return &RetryPolicy{
+		MaxElapsed:      30 * time.Second,
+		InitialInterval: 50 * time.Millisecond,
+		MaxInterval:     time.Second,
+		OnRetry: func(operation string, attempt int, err error, delay time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			t.Logf("retrying %s after attempt #%d: %v", operation, attempt, err)
+		},
+	}
+}
+
+func TestKeyValueSurvivesLeaderFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	servers := startCluster(t, 3)
+	js := connectCluster(t, servers)
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "failover", Replicas: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	policy := failoverPolicy(t)
+	rev, err := RobustCreateKVEntry(ctx, policy, kv, "key", []byte("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	killStreamLeader(t, js, servers, "KV_failover")
+
+	rev, err = RobustUpdateKVEntry(ctx, policy, kv, "key", rev, []byte("2"))
+	if err != nil {
+		t.Fatalf("update after failover: %v", err)
+	}
+	entry, err := RobustGetKVEntry(ctx, policy, kv, "key")
+	if err != nil {
+		t.Fatalf("get after failover: %v", err)
+	}
+	if string(entry.Value()) != "2" || entry.Revision() != rev {
+		t.Fatalf("got %q at revision %d, want \"2\" at %d", entry.Value(), entry.Revision(), rev)
+	}
+
+	// Conflicts stay permanent
+	retries := 0
+	policy.OnRetry = func(string, int, error, time.Duration) { retries++ }
+	if _, err = RobustCreateKVEntry(ctx, policy, kv, "key", []byte("3")); err == nil || retries != 0 {
+		t.Fatalf("create of an existing key got %v after %d retries, want a conflict without retries", err, retries)
+	}
+}
+
+func TestObjectStoreSurvivesLeaderFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	servers := startCluster(t, 3)
+	js := connectCluster(t, servers)
+	osb, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "failover", Replicas: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	policy := failoverPolicy(t)
+	content := bytes.Repeat([]byte("chunk"), 100_000) // Several chunks
+	if _, err = RobustPutObject(ctx, policy, osb, bytes.NewReader(content), "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	killStreamLeader(t, js, servers, "OBJ_failover")
+
+	if _, err = RobustPutObject(ctx, policy, osb, bytes.NewReader(content), "after"); err != nil {
+		t.Fatalf("put after failover: %v", err)
+	}
+	for _, name := range []string{"before", "after"} {
+		data, err := RobustGetObjectBytes(ctx, policy, osb, name)
+		if err != nil {
+			t.Fatalf("get %s after failover: %v", name, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Fatalf("content of %s differs", name)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package nats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exec/common"
+	"exec/memory"
+	"exec/nats/natstest"
+	"github.com/nats-io/nats.go"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testValue struct {
+	Value int
+}
+
+// testKeyValueBucket checks the semantics common to all buckets, the bucket has to keep a history of at least 5 entries
+func testKeyValueBucket(t *testing.T, bucket common.KeyValueBucket[testValue]) {
+	ctx := context.Background()
+	if keys, err := bucket.Keys(ctx); err != nil || len(keys) != 0 {
+		t.Fatalf("keys of an empty bucket: %v, %v", keys, err)
+	}
+	if _, err := bucket.History("a", ctx); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("history of a missing key: %v", err)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates, err := bucket.Watch("tasks.*", watchCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err := bucket.Create("tasks.a", &testValue{Value: 1}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bucket.Create("tasks.b", &testValue{Value: 10}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bucket.Create("other", &testValue{Value: 100}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err = bucket.Update("tasks.a", &testValue{Value: 2}, rev, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = bucket.Delete("tasks.a", rev-1, ctx); !errors.Is(err, common.ErrWrongRevNumber) {
+		t.Fatalf("delete of a stale revision: %v", err)
+	}
+	if err = bucket.Delete("tasks.a", rev, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bucket.Get("tasks.a", ctx); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("get of a deleted key: %v", err)
+	}
+	if err = bucket.Purge("tasks.b", 0, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	type update struct {
+		key   string
+		op    common.KeyValueOp
+		value int
+	}
+	expected := []update{
+		{"tasks.a", common.KeyValuePut, 1},
+		{"tasks.b", common.KeyValuePut, 10},
+		{"tasks.a", common.KeyValuePut, 2},
+		{"tasks.a", common.KeyValueDelete, 0},
+		{"tasks.b", common.KeyValuePurge, 0},
+	}
+	for _, e := range expected {
+		select {
+		case entry := <-updates:
+			got := update{key: entry.Key(), op: entry.Operation()}
+			if entry.Value() != nil {
+				got.value = entry.Value().Value
+			}
+			if got != e {
+				t.Fatalf("watched %+v, expected %+v", got, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no update %+v", e)
+		}
+	}
+
+	keys, err := bucket.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "other" {
+		t.Fatalf("expected only other to be left, got %v", keys)
+	}
+
+	history, err := bucket.History("tasks.a", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []common.KeyValueOp{common.KeyValuePut, common.KeyValuePut, common.KeyValueDelete}
+	if len(history) != len(ops) {
+		t.Fatalf("expected %d entries of history, got %d", len(ops), len(history))
+	}
+	for i, entry := range history {
+		if entry.Operation() != ops[i] {
+			t.Fatalf("history entry #%d is %d, expected %d", i, entry.Operation(), ops[i])
+		}
+	}
+	if history[1].Value().Value != 2 || history[1].Revision() != rev {
+		t.Fatalf("unexpected history entry %d@%d", history[1].Value().Value, history[1].Revision())
+	}
+	// Deleted keys may be created again
+	if _, err = bucket.Create("tasks.a", &testValue{Value: 3}, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err = bucket.History("tasks.b", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Operation() != common.KeyValuePurge {
+		t.Fatalf("expected only the purge to be left of tasks.b, got %d entries", len(history))
+	}
+}
+
+// testWatchSkipsUndecodable writes an entry of another type through other, both have to be over the same bucket.
+// logged is written by the logger of bucket
+func testWatchSkipsUndecodable(
+	t *testing.T,
+	bucket common.KeyValueBucket[testValue],
+	other common.KeyValueBucket[string],
+	logged *bytes.Buffer,
+) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := bucket.Watch("mixed.*", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "not a testValue"
+	if _, err = other.Create("mixed.bad", &text, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bucket.Create("mixed.good", &testValue{Value: 1}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case entry, ok := <-updates:
+		if !ok {
+			t.Fatal("the watch ended at an undecodable entry")
+		}
+		if entry.Key() != "mixed.good" {
+			t.Fatalf("watched %s instead of mixed.good", entry.Key())
+		}
+		if !strings.Contains(logged.String(), "mixed.bad") {
+			t.Fatalf("the skipped entry wasn't logged: %q", logged.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after an undecodable entry")
+	}
+}
+
+func TestNatsKeyValueBucket(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a nats server")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "test", History: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeyValueBucket(t, NewKeyValueTypedWrapper[testValue](kv, &common.JsonSerializer[testValue]{}, DefaultRetryPolicy()))
+	var logged bytes.Buffer
+	testWatchSkipsUndecodable(
+		t,
+		NewKeyValueTypedWrapper[testValue](kv, &common.JsonSerializer[testValue]{}, DefaultRetryPolicy(), common.WithLogger(log.New(&logged, "", 0))),
+		NewKeyValueTypedWrapper[string](kv, &common.JsonSerializer[string]{}, DefaultRetryPolicy()),
+		&logged,
+	)
+}
+
+func TestMemoryKeyValueBucket(t *testing.T) {
+	testKeyValueBucket(t, memory.NewKeyValueBucket[testValue](memory.NewKeyValue(), &common.JsonSerializer[testValue]{}))
+	kv := memory.NewKeyValue()
+	var logged bytes.Buffer
+	testWatchSkipsUndecodable(
+		t,
+		memory.NewKeyValueBucket[testValue](kv, &common.JsonSerializer[testValue]{}, common.WithLogger(log.New(&logged, "", 0))),
+		memory.NewKeyValueBucket[string](kv, &common.JsonSerializer[string]{}),
+		&logged,
+	)
+}
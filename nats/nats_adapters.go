@@ -5,17 +5,22 @@ import (
 	"errors"
 	"exec/common"
 	"github.com/nats-io/nats.go"
+	"log"
+	"net/http"
+	"sync"
 )
 
 type jsSubscriptionTypedWrapper[T any] struct {
 	sub        *nats.Subscription
 	serializer common.Serializer[T]
+	policy     *RetryPolicy
 }
 
-func NewSubscriptionWrapper[T any](sub *nats.Subscription, serializer common.Serializer[T]) common.PullSubscriber[T] {
+func NewSubscriptionWrapper[T any](sub *nats.Subscription, serializer common.Serializer[T], policy *RetryPolicy) common.PullSubscriber[T] {
 	return &jsSubscriptionTypedWrapper[T]{
 		sub:        sub,
 		serializer: serializer,
+		policy:     policy,
 	}
 }
 
@@ -28,6 +33,17 @@ func (msg *DeserializedNatsMsg[T]) Content() *T {
 	return msg.content
 }
 
+// Headers have canonical keys like http headers, nats keeps them as published
+func (msg *DeserializedNatsMsg[T]) Headers() http.Header {
+	headers := make(http.Header, len(msg.Msg.Header))
+	for key, values := range msg.Msg.Header {
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+	return headers
+}
+
 func (msg *DeserializedNatsMsg[T]) Ack() error {
 	return msg.Msg.AckSync()
 }
@@ -41,7 +57,7 @@ func (msg *DeserializedNatsMsg[T]) AckInProgress() error {
 }
 
 func (js *jsSubscriptionTypedWrapper[T]) Fetch(n int, ctx context.Context) ([]common.Message[T], error) {
-	msgs, err := RobustFetch(js.sub, n, ctx)
+	msgs, err := RobustFetch(ctx, js.policy, js.sub, n)
 	if err != nil {
 		return nil, err
 	}
@@ -62,33 +78,53 @@ func (js *jsSubscriptionTypedWrapper[T]) Fetch(n int, ctx context.Context) ([]co
 type jsPublisherTypedWrapper[T any] struct {
 	js         nats.JetStream
 	serializer common.Serializer[T]
+	policy     *RetryPolicy
 }
 
-func NewPublisherWrapper[T any](js nats.JetStream, serializer common.Serializer[T]) common.Publisher[T] {
+func NewPublisherWrapper[T any](js nats.JetStream, serializer common.Serializer[T], policy *RetryPolicy) common.Publisher[T] {
 	return &jsPublisherTypedWrapper[T]{
 		js:         js,
 		serializer: serializer,
+		policy:     policy,
 	}
 }
 
-func (js *jsPublisherTypedWrapper[T]) PublishSync(subject string, v *T) error {
+func (js *jsPublisherTypedWrapper[T]) PublishSync(subject string, v *T, opts ...common.PublishOpt) error {
 	data, err := js.serializer.Serialize(v)
 	if err != nil {
 		return err
 	}
-	_, err = RobustPublishSync(js.js, subject, data)
+	options := common.NewPublishOptions(opts...)
+	msg := &nats.Msg{Subject: subject, Data: data, Header: nats.Header(options.Headers.Clone())}
+	var pubOpts []nats.PubOpt
+	if options.MsgId != "" {
+		pubOpts = append(pubOpts, nats.MsgId(options.MsgId))
+	}
+	if options.ExpectedStream != "" {
+		pubOpts = append(pubOpts, nats.ExpectStream(options.ExpectedStream))
+	}
+	_, err = RobustPublishMsg(context.Background(), js.policy, js.js, msg, pubOpts...)
 	return err
 }
 
 type keyValueTypedWrapper[T any] struct {
 	kv         nats.KeyValue
 	serializer common.Serializer[T]
+	policy     *RetryPolicy
+	logger     *log.Logger
 }
 
-func NewKeyValueTypedWrapper[T any](kv nats.KeyValue, serializer common.Serializer[T]) common.KeyValueBucket[T] {
+func NewKeyValueTypedWrapper[T any](
+	kv nats.KeyValue,
+	serializer common.Serializer[T],
+	policy *RetryPolicy,
+	opts ...common.KeyValueOpt,
+) common.KeyValueBucket[T] {
 	return &keyValueTypedWrapper[T]{
 		kv:         kv,
 		serializer: serializer,
+		policy:     policy,
+		logger:     common.NewKeyValueOptions(opts...).Logger,
 	}
 }
 
@@ -109,10 +145,21 @@ func (e *DeserializedKVEntry[T]) Revision() uint64 {
 	return e.entry.Revision()
 }
 
-func (kv *keyValueTypedWrapper[T]) Get(key string) (common.KeyValueEntry[T], error) {
-	entry, err := RobustGetKVEntry(kv.kv, key)
-	if err != nil {
-		return nil, err
+func (e *DeserializedKVEntry[T]) Operation() common.KeyValueOp {
+	switch e.entry.Operation() {
+	case nats.KeyValueDelete:
+		return common.KeyValueDelete
+	case nats.KeyValuePurge:
+		return common.KeyValuePurge
+	default:
+		return common.KeyValuePut
+	}
+}
+
+// deserialize leaves the content of deletes and purges nil
+func (kv *keyValueTypedWrapper[T]) deserialize(entry nats.KeyValueEntry) (*DeserializedKVEntry[T], error) {
+	if entry.Operation() != nats.KeyValuePut {
+		return &DeserializedKVEntry[T]{entry: entry}, nil
 	}
 	content, err := kv.serializer.Deserialize(entry.Value())
 	if err != nil {
@@ -124,32 +171,39 @@ func (kv *keyValueTypedWrapper[T]) Get(key string) (common.KeyValueEntry[T], err
 	}, nil
 }
 
-func (kv *keyValueTypedWrapper[T]) Create(key string, value *T) (uint64, error) {
+func (kv *keyValueTypedWrapper[T]) Get(key string, ctx context.Context) (common.KeyValueEntry[T], error) {
+	entry, err := RobustGetKVEntry(ctx, kv.policy, kv.kv, key)
+	if err != nil {
+		return nil, err
+	}
+	return kv.deserialize(entry)
+}
+
+func (kv *keyValueTypedWrapper[T]) Create(key string, value *T, ctx context.Context) (uint64, error) {
 	data, err := kv.serializer.Serialize(value)
 	if err != nil {
 		return 0, err
 	}
-	return RobustCreateKVEntry(kv.kv, key, data)
+	return RobustCreateKVEntry(ctx, kv.policy, kv.kv, key, data)
 }
 
-func (kv *keyValueTypedWrapper[T]) Update(key string, value *T, last uint64) (uint64, error) {
+func (kv *keyValueTypedWrapper[T]) Update(key string, value *T, last uint64, ctx context.Context) (uint64, error) {
 	data, err := kv.serializer.Serialize(value)
-	if errors.Is(err, nats.ErrKeyExists) {
-		return 0, common.ErrWrongRevNumber
-	}
 	if err != nil {
 		return 0, err
 	}
-	return RobustUpdateKVEntry(kv.kv, key, last, data)
+	rev, err := RobustUpdateKVEntry(ctx, kv.policy, kv.kv, key, last, data)
+	return rev, kv.revisionError(err)
 }
 
 func (kv *keyValueTypedWrapper[T]) CAS(
 	key string,
 	stopPredicate func(*T) (bool, error),
 	alter func(*T) error,
+	ctx context.Context,
 ) (*T, uint64, error) {
 	for {
-		entry, err := kv.Get(key)
+		entry, err := kv.Get(key, ctx)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -165,7 +219,7 @@ func (kv *keyValueTypedWrapper[T]) CAS(
 		if err != nil {
 			return nil, 0, err
 		}
-		newRev, err := kv.Update(key, value, entry.Revision())
+		newRev, err := kv.Update(key, value, entry.Revision(), ctx)
 		if errors.Is(err, common.ErrWrongRevNumber) {
 			continue
 		}
@@ -175,3 +229,159 @@ func (kv *keyValueTypedWrapper[T]) CAS(
 		return value, newRev, nil
 	}
 }
+
+func (kv *keyValueTypedWrapper[T]) Watch(key string, ctx context.Context) (<-chan common.KeyValueEntry[T], error) {
+	watcher, err := RobustWatchKV(ctx, kv.policy, kv.kv, key)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan common.KeyValueEntry[T])
+	go func() {
+		defer close(ch)
+		defer func() {
+			_ = watcher.Stop()
+		}()
+		for {
+			var entry nats.KeyValueEntry
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-watcher.Updates():
+				if !ok {
+					return
+				}
+				entry = e
+			}
+			// nil marks the end of initial values
+			if entry == nil {
+				continue
+			}
+			typed, err := kv.deserialize(entry)
+			if err != nil {
+				// One bad entry mustn't end the watch of all the others
+				kv.logger.Printf("Skipping undecodable entry %s of the watch: %v", entry.Key(), err)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- typed:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (kv *keyValueTypedWrapper[T]) Keys(ctx context.Context) ([]string, error) {
+	keys, err := RobustKVKeys(ctx, kv.policy, kv.kv)
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (kv *keyValueTypedWrapper[T]) History(key string, ctx context.Context) ([]common.KeyValueEntry[T], error) {
+	entries, err := RobustKVHistory(ctx, kv.policy, kv.kv, key)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]common.KeyValueEntry[T], len(entries))
+	for i, entry := range entries {
+		typed, err := kv.deserialize(entry)
+		if err != nil {
+			return nil, err
+		}
+		history[i] = typed
+	}
+	return history, nil
+}
+
+func (kv *keyValueTypedWrapper[T]) Delete(key string, last uint64, ctx context.Context) error {
+	return kv.revisionError(RobustDeleteKVEntry(ctx, kv.policy, kv.kv, key, lastRevision(last)...))
+}
+
+func (kv *keyValueTypedWrapper[T]) Purge(key string, last uint64, ctx context.Context) error {
+	return kv.revisionError(RobustPurgeKVEntry(ctx, kv.policy, kv.kv, key, lastRevision(last)...))
+}
+
+// revisionError maps the error of a revision mismatch, which nats reports as an existing key
+func (kv *keyValueTypedWrapper[T]) revisionError(err error) error {
+	if errors.Is(err, nats.ErrKeyExists) {
+		return common.ErrWrongRevNumber
+	}
+	return err
+}
+
+func lastRevision(last uint64) []nats.DeleteOpt {
+	if last == 0 {
+		return nil
+	}
+	return []nats.DeleteOpt{nats.LastRevision(last)}
+}
+
+type coreBroadcasterTypedWrapper[T any] struct {
+	nc         *nats.Conn
+	serializer common.Serializer[T]
+}
+
+func NewBroadcasterWrapper[T any](nc *nats.Conn, serializer common.Serializer[T]) common.Broadcaster[T] {
+	return &coreBroadcasterTypedWrapper[T]{
+		nc:         nc,
+		serializer: serializer,
+	}
+}
+
+func (b *coreBroadcasterTypedWrapper[T]) Broadcast(subject string, msg *T) error {
+	data, err := b.serializer.Serialize(msg)
+	if err != nil {
+		return err
+	}
+	return b.nc.Publish(subject, data)
+}
+
+type coreListenerTypedWrapper[T any] struct {
+	nc         *nats.Conn
+	serializer common.Serializer[T]
+}
+
+func NewListenerWrapper[T any](nc *nats.Conn, serializer common.Serializer[T]) common.Listener[T] {
+	return &coreListenerTypedWrapper[T]{
+		nc:         nc,
+		serializer: serializer,
+	}
+}
+
+func (l *coreListenerTypedWrapper[T]) Listen(subject string, bufferSize int, ctx context.Context) (<-chan *T, error) {
+	ch := make(chan *T, bufferSize)
+	var mu sync.Mutex
+	closed := false
+	sub, err := l.nc.Subscribe(subject, func(msg *nats.Msg) {
+		content, err := l.serializer.Deserialize(msg.Data)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- content:
+		default:
+			// Slow reader, drop the message instead of blocking the connection. Messages which need
+			// the drops reported have to carry sequence numbers
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = sub.Unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	}()
+	return ch, nil
+}
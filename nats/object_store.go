@@ -0,0 +1,92 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"github.com/nats-io/nats.go"
+	"io"
+	"net/http"
+)
+
+type objectStoreWrapper struct {
+	osb    nats.ObjectStore
+	policy *RetryPolicy
+}
+
+// NewObjectStoreWrapper adapts the bucket to common.ObjectStore, retrying transient errors by the policy
+func NewObjectStoreWrapper(osb nats.ObjectStore, policy *RetryPolicy) common.ObjectStore {
+	return &objectStoreWrapper{osb: osb, policy: policy}
+}
+
+func toObjectInfo(info *nats.ObjectInfo) *common.ObjectInfo {
+	return &common.ObjectInfo{
+		ObjectMeta: common.ObjectMeta{Name: info.Name, Headers: http.Header(info.Headers)},
+		Size:       info.Size,
+		Digest:     info.Digest,
+		ModTime:    info.ModTime,
+	}
+}
+
+func objectError(err error) error {
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return common.ErrObjectNotFound
+	}
+	return err
+}
+
+func (s *objectStoreWrapper) Put(meta *common.ObjectMeta, data io.Reader, ctx context.Context) (*common.ObjectInfo, error) {
+	info, err := RobustPutObjectWithMeta(ctx, s.policy, s.osb, data, &nats.ObjectMeta{
+		Name:    meta.Name,
+		Headers: nats.Header(meta.Headers),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toObjectInfo(info), nil
+}
+
+func (s *objectStoreWrapper) Get(name string, ctx context.Context) ([]byte, error) {
+	data, err := RobustGetObjectBytes(ctx, s.policy, s.osb, name)
+	return data, objectError(err)
+}
+
+func (s *objectStoreWrapper) GetInfo(name string, ctx context.Context) (*common.ObjectInfo, error) {
+	info, err := RobustGetObjectInfo(ctx, s.policy, s.osb, name)
+	if err != nil {
+		return nil, objectError(err)
+	}
+	return toObjectInfo(info), nil
+}
+
+func (s *objectStoreWrapper) Delete(name string, ctx context.Context) error {
+	return objectError(RobustDeleteObject(ctx, s.policy, s.osb, name))
+}
+
+// Stream skips to offset, object stores of nats can't read from the middle of objects
+func (s *objectStoreWrapper) Stream(name string, offset int64, ctx context.Context) (io.ReadCloser, error) {
+	result, err := RobustGetObject(ctx, s.policy, s.osb, name)
+	if err != nil {
+		return nil, objectError(err)
+	}
+	if _, err = io.CopyN(io.Discard, result, offset); err != nil && err != io.EOF {
+		_ = result.Close()
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *objectStoreWrapper) List(ctx context.Context) ([]*common.ObjectInfo, error) {
+	infos, err := RobustListObjects(ctx, s.policy, s.osb)
+	if errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*common.ObjectInfo, len(infos))
+	for i, info := range infos {
+		ret[i] = toObjectInfo(info)
+	}
+	return ret, nil
+}
@@ -0,0 +1,83 @@
+package nats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"exec/common"
+	"exec/nats/natstest"
+	"github.com/nats-io/nats.go"
+	"io"
+	"sync"
+	"testing"
+)
+
+// Reads with a context have to be race-free, nats.go can't be given the context itself
+func TestObjectStoreReadsWithContext(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a nats server")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	obs, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	osb := NewObjectStoreWrapper(obs, DefaultRetryPolicy())
+	content := bytes.Repeat([]byte("chunk"), 100_000) // Several chunks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err = osb.Put(&common.ObjectMeta{Name: "object"}, bytes.NewReader(content), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = osb.Put(&common.ObjectMeta{Name: "empty"}, bytes.NewReader(nil), ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < cap(errs)/2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			data, err := osb.Get("object", ctx)
+			if err == nil && !bytes.Equal(data, content) {
+				err = errors.New("content differs")
+			}
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			stream, err := osb.Stream("object", 5, ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer stream.Close()
+			data, err := io.ReadAll(stream)
+			if err == nil && !bytes.Equal(data, content[5:]) {
+				err = errors.New("streamed content differs")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := osb.Get("empty", ctx); err != nil || len(data) != 0 {
+		t.Fatalf("empty object: %q, %v", data, err)
+	}
+
+	stream, err := osb.Stream("object", 0, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	cancel()
+	if _, err = io.ReadAll(stream); !errors.Is(err, context.Canceled) {
+		t.Fatalf("read after cancellation: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"exec/common"
+	"exec/memory"
+	"exec/nats/natstest"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+// testPublisher expects pub to publish to the stream "tasks" consumed by sub
+func testPublisher(t *testing.T, pub common.Publisher[testValue], sub common.PullSubscriber[testValue], wrongStream func(error) bool) {
+	for i := 0; i < 3; i++ {
+		err := pub.PublishSync("tasks", &testValue{Value: 1}, common.WithMsgId("task-1"), common.WithHeader("Tenant", "alice"), common.WithHeader("x-trace-id", "1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pub.PublishSync("tasks", &testValue{Value: 2}, common.WithMsgId("task-2"), common.WithExpectedStream("tasks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pub.PublishSync("tasks", &testValue{Value: 3}, common.WithExpectedStream("other"))
+	if !wrongStream(err) {
+		t.Fatalf("publishing to an unexpected stream: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var values []int
+	for len(values) < 2 {
+		msgs, err := sub.Fetch(2, ctx)
+		if err != nil {
+			t.Fatalf("fetched %v: %v", values, err)
+		}
+		for _, msg := range msgs {
+			values = append(values, msg.Content().Value)
+			if msg.Content().Value == 1 && (msg.Headers().Get("Tenant") != "alice" || msg.Headers().Get("X-Trace-Id") != "1") {
+				t.Fatalf("unexpected headers %v", msg.Headers())
+			}
+			if err = msg.Ack(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if values[0] != 1 || values[1] != 2 {
+		t.Fatalf("expected the deduplicated messages 1 and 2, got %v", values)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if msgs, err := sub.Fetch(1, ctx); len(msgs) != 0 {
+		t.Fatalf("expected no more messages, got %d: %v", len(msgs), err)
+	}
+}
+
+func TestNatsPublisher(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a nats server")
+	}
+	_, js := natstest.Connect(t, natstest.RunJetStream(t))
+	_, err := js.AddStream(&nats.StreamConfig{Name: "tasks", Retention: nats.WorkQueuePolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = js.AddConsumer("tasks", &nats.ConsumerConfig{Durable: "workers", AckPolicy: nats.AckExplicitPolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := js.PullSubscribe("", "workers", nats.Bind("tasks", "workers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializer := &common.JsonSerializer[testValue]{}
+	policy := DefaultRetryPolicy()
+	testPublisher(t, NewPublisherWrapper[testValue](js, serializer, policy), NewSubscriptionWrapper[testValue](sub, serializer, policy), func(err error) bool {
+		return err != nil
+	})
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	queue := memory.NewQueue("tasks", time.Minute, "tasks")
+	serializer := &common.JsonSerializer[testValue]{}
+	testPublisher(t, memory.NewPublisher[testValue](queue, serializer), memory.NewSubscriber[testValue](queue, serializer), func(err error) bool {
+		return errors.Is(err, memory.ErrWrongStream)
+	})
+}
@@ -0,0 +1,102 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cenkalti/backoff/v4"
+	"time"
+)
+
+// RetryPolicy bounds the retries of Robust* operations, zero fields fall back to DefaultRetryPolicy.
+// Retries stop at whichever of MaxAttempts and MaxElapsed is reached first, or when the context is done
+type RetryPolicy struct {
+	MaxAttempts         int // Including the first one, 0 means unlimited
+	MaxElapsed          time.Duration
+	InitialInterval     time.Duration
+	MaxInterval         time.Duration
+	Multiplier          float64
+	RandomizationFactor float64
+
+	// Classifier decides which errors are retried, IsTransientError if nil
+	Classifier ErrorClassifier
+
+	// OnRetry is called before waiting delay for the next attempt, e.g. to count retries per operation
+	OnRetry func(operation string, attempt int, err error, delay time.Duration)
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxElapsed:          30 * time.Second,
+		InitialInterval:     100 * time.Millisecond,
+		MaxInterval:         5 * time.Second,
+		Multiplier:          1.5,
+		RandomizationFactor: 0.5,
+	}
+}
+
+func (p *RetryPolicy) backOff() *backoff.ExponentialBackOff {
+	defaults := DefaultRetryPolicy()
+	back := backoff.NewExponentialBackOff()
+	back.MaxElapsedTime = orDefault(p.MaxElapsed, defaults.MaxElapsed)
+	back.InitialInterval = orDefault(p.InitialInterval, defaults.InitialInterval)
+	back.MaxInterval = orDefault(p.MaxInterval, defaults.MaxInterval)
+	back.Multiplier = orDefault(p.Multiplier, defaults.Multiplier)
+	back.RandomizationFactor = orDefault(p.RandomizationFactor, defaults.RandomizationFactor)
+	back.Reset()
+	return back
+}
+
+func orDefault[T comparable](value T, def T) T {
+	var zero T
+	if value == zero {
+		return def
+	}
+	return value
+}
+
+func (p *RetryPolicy) isTransient(ctx context.Context, err error) bool {
+	// Operations given a context without deadline time out with it instead of nats.ErrTimeout
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return true
+	}
+	if p.Classifier == nil {
+		return IsTransientError(err)
+	}
+	return p.Classifier(err)
+}
+
+// retry returns the last error once it's permanent or the policy is exhausted,
+// or it along with the context error if the context is done
+func (p *RetryPolicy) retry(ctx context.Context, operation string, action func() error) error {
+	if p == nil {
+		p = DefaultRetryPolicy()
+	}
+	back := p.backOff()
+	for attempt := 1; ; attempt++ {
+		err := action()
+		if err == nil || !p.isTransient(ctx, err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %w: %w", operation, ctx.Err(), err)
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+		delay := back.NextBackOff()
+		if delay == backoff.Stop {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(operation, attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s: %w: %w", operation, ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
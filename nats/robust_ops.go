@@ -4,59 +4,50 @@ import (
 	"context"
 	"errors"
 	"exec/common"
-	"github.com/cenkalti/backoff/v4"
 	"github.com/nats-io/nats.go"
 	"io"
+	"net"
 	"os"
-	"time"
 )
 
-func retryOnPredicate(action func() error, retryPredicate func(error) bool) error {
-	back := backoff.NewExponentialBackOff()
-
-	for {
-		err := action()
-		if retryPredicate(err) {
-			time.Sleep(back.NextBackOff())
-			continue
-		}
-		return err
-	}
-
-}
-
-func retryOnError(action func() error, errorList []error) error {
-	return retryOnPredicate(
-		action,
-		func(err error) bool {
-			for _, e := range errorList {
-				if errors.Is(err, e) {
-					return true
-				}
+func RobustPublishSync(ctx context.Context, policy *RetryPolicy, js nats.JetStream, subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	var ack *nats.PubAck = nil
+	err := policy.retry(
+		ctx,
+		"publish",
+		func() error {
+			a, err := js.Publish(subj, data, append(opts, nats.Context(ctx))...)
+			if err == nil {
+				ack = a
 			}
-			return false
+			return err
 		},
 	)
+	return ack, err
 }
 
-func RobustPublishSync(js nats.JetStream, subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+// RobustPublishMsg is RobustPublishSync for messages with headers, retries of a message with a msg id are deduplicated
+func RobustPublishMsg(ctx context.Context, policy *RetryPolicy, js nats.JetStream, msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
 	var ack *nats.PubAck = nil
-	err := retryOnError(
+	err := policy.retry(
+		ctx,
+		"publish",
 		func() error {
-			a, err := js.Publish(subj, data, opts...)
+			a, err := js.PublishMsg(msg, append(opts, nats.Context(ctx))...)
 			if err == nil {
 				ack = a
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
 	return ack, err
 }
 
-func RobustFetch(sub *nats.Subscription, n int, ctx context.Context, opts ...nats.PullOpt) ([]*nats.Msg, error) {
+func RobustFetch(ctx context.Context, policy *RetryPolicy, sub *nats.Subscription, n int, opts ...nats.PullOpt) ([]*nats.Msg, error) {
 	var msgs []*nats.Msg = nil
-	return msgs, retryOnError(
+	return msgs, policy.retry(
+		ctx,
+		"fetch",
 		func() error {
 			m, err := sub.Fetch(n, append(opts, nats.Context(ctx))...)
 			if err == nil {
@@ -64,102 +55,336 @@ func RobustFetch(sub *nats.Subscription, n int, ctx context.Context, opts ...nat
 			}
 			return err
 		},
-		[]error{nats.ErrConsumerLeadershipChanged, nats.ErrTimeout},
 	)
 }
 
-func RobustGetObjectFile(osb nats.ObjectStore, id string, filePath string, opts ...nats.GetObjectOpt) error {
-	return retryOnError(
+func RobustGetObjectFile(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, id string, filePath string, opts ...nats.GetObjectOpt) error {
+	return policy.retry(
+		ctx,
+		"get-object-file",
 		func() error {
-			return osb.GetFile(id, filePath, opts...)
+			result, err := osb.Get(id, opts...)
+			if err != nil {
+				return err
+			}
+			// Closed before a retry opens the object again
+			defer result.Close()
+			file, err := os.Create(filePath)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, &contextObjectResult{ObjectResult: result, ctx: ctx})
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
 }
 
-func RobustGetObjectBytes(osb nats.ObjectStore, id string, opts ...nats.GetObjectOpt) ([]byte, error) {
+// contextObjectResult bounds reads of an object by ctx. The context can't be passed to nats.go (v1.25) itself,
+// the chunk handler of obs.Get races with Get over its error variable when it has one
+type contextObjectResult struct {
+	nats.ObjectResult
+	ctx context.Context
+}
+
+func (r *contextObjectResult) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.ObjectResult.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// No chunk arrived for a while, e.g. during a failover. nats.go keeps waiting too when it has the context
+		return n, r.ctx.Err()
+	}
+	return n, err
+}
+
+// RobustGetObject retries opening the object, the result has to be closed
+func RobustGetObject(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, id string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+	var result nats.ObjectResult
+	err := policy.retry(
+		ctx,
+		"get-object",
+		func() error {
+			r, err := osb.Get(id, opts...)
+			if err == nil {
+				result = &contextObjectResult{ObjectResult: r, ctx: ctx}
+			}
+			return err
+		},
+	)
+	return result, err
+}
+
+func RobustGetObjectBytes(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, id string, opts ...nats.GetObjectOpt) ([]byte, error) {
 	var data []byte
-	return data, retryOnError(
+	return data, policy.retry(
+		ctx,
+		"get-object",
 		func() error {
-			d, err := osb.GetBytes(id, opts...)
+			result, err := osb.Get(id, opts...)
+			if err != nil {
+				return err
+			}
+			// Closed before a retry opens the object again
+			defer result.Close()
+			d, err := io.ReadAll(&contextObjectResult{ObjectResult: result, ctx: ctx})
 			if err == nil {
 				data = d
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
 }
 
-func RobustPutObject(osb nats.ObjectStore, object io.Reader, objectName string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+func RobustPutObject(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, object io.Reader, objectName string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return RobustPutObjectWithMeta(ctx, policy, osb, object, &nats.ObjectMeta{Name: objectName}, opts...)
+}
+
+// RobustPutObjectWithMeta rewinds the object before every retry if it's an io.Seeker, otherwise it isn't retried
+func RobustPutObjectWithMeta(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, object io.Reader, meta *nats.ObjectMeta, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
 	var objectInfo *nats.ObjectInfo
-	return objectInfo, retryOnError(
+	seeker, seekable := object.(io.Seeker)
+	attempted := false
+	return objectInfo, policy.retry(
+		ctx,
+		"put-object",
 		func() error {
-			oi, err := osb.Put(&nats.ObjectMeta{Name: objectName}, object, opts...)
+			if attempted {
+				if !seekable {
+					return errors.New("can't retry the upload of an unseekable object")
+				}
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+			}
+			attempted = true
+			oi, err := osb.Put(meta, object, append(opts, nats.Context(ctx))...)
 			if err == nil {
 				objectInfo = oi
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
+}
 
+func RobustPutObjectFile(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, filePath string, objectName string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return RobustPutObjectFileWithMeta(ctx, policy, osb, filePath, &nats.ObjectMeta{Name: objectName}, opts...)
 }
 
-func RobustPutObjectFile(osb nats.ObjectStore, filePath string, objectName string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+func RobustPutObjectFileWithMeta(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, filePath string, meta *nats.ObjectMeta, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
-	return RobustPutObject(osb, file, objectName, opts...)
+	defer file.Close()
+	return RobustPutObjectWithMeta(ctx, policy, osb, file, meta, opts...)
 }
 
-func RobustPubObjectFileRandomName(osb nats.ObjectStore, filePath string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
-	return RobustPutObjectFile(osb, filePath, common.GetRandomId(), opts...)
+func RobustPubObjectFileRandomName(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, filePath string, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return RobustPutObjectFile(ctx, policy, osb, filePath, common.GetRandomId(), opts...)
 }
 
-func RobustPutObjectRandomName(osb nats.ObjectStore, object io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
-	return RobustPutObject(osb, object, common.GetRandomId(), opts...)
+func RobustPutObjectRandomName(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, object io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	return RobustPutObject(ctx, policy, osb, object, common.GetRandomId(), opts...)
 }
 
-func RobustGetKVEntry(kvb nats.KeyValue, key string) (nats.KeyValueEntry, error) {
+func RobustGetObjectInfo(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, id string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+	var objectInfo *nats.ObjectInfo
+	return objectInfo, policy.retry(
+		ctx,
+		"get-object-info",
+		func() error {
+			oi, err := osb.GetInfo(id, append(opts, nats.Context(ctx))...)
+			if err == nil {
+				objectInfo = oi
+			}
+			return err
+		},
+	)
+}
+
+// RobustDeleteObject may fail with nats.ErrObjectNotFound when an interrupted attempt has deleted the object already
+func RobustDeleteObject(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore, id string) error {
+	return policy.retry(
+		ctx,
+		"delete-object",
+		func() error {
+			return osb.Delete(id)
+		},
+	)
+}
+
+func RobustListObjects(ctx context.Context, policy *RetryPolicy, osb nats.ObjectStore) ([]*nats.ObjectInfo, error) {
+	var result []*nats.ObjectInfo
+	return result, policy.retry(
+		ctx,
+		"list-objects",
+		func() error {
+			infos, err := osb.List(nats.Context(ctx))
+			if err == nil {
+				result = infos
+			}
+			return err
+		},
+	)
+}
+
+// withContext bounds an attempt by ctx where nats.go (v1.25) doesn't take a context, like gets and updates of keys.
+// The attempt isn't interrupted, it carries on in the background and its result is dropped
+func withContext[T any](ctx context.Context, attempt func() (T, error)) (T, error) {
+	if ctx.Done() == nil {
+		return attempt()
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := attempt()
+		done <- result{value: value, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func RobustGetKVEntry(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string) (nats.KeyValueEntry, error) {
 	var result nats.KeyValueEntry
-	return result, retryOnError(
+	return result, policy.retry(
+		ctx,
+		"kv-get",
 		func() error {
-			r, err := kvb.Get(key)
+			r, err := withContext(ctx, func() (nats.KeyValueEntry, error) {
+				return kvb.Get(key)
+			})
 			if err == nil {
 				result = r
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
 }
 
-func RobustCreateKVEntry(kvb nats.KeyValue, key string, value []byte) (uint64, error) {
+func RobustCreateKVEntry(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string, value []byte) (uint64, error) {
 	var result uint64
-	return result, retryOnError(
+	return result, policy.retry(
+		ctx,
+		"kv-create",
 		func() error {
-			r, err := kvb.Create(key, value)
+			r, err := withContext(ctx, func() (uint64, error) {
+				return kvb.Create(key, value)
+			})
 			if err == nil {
 				result = r
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
 	)
 }
 
-func RobustUpdateKVEntry(kvb nats.KeyValue, key string, last uint64, newVal []byte) (uint64, error) {
+func RobustUpdateKVEntry(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string, last uint64, newVal []byte) (uint64, error) {
 	var result uint64
-	return result, retryOnError(
+	return result, policy.retry(
+		ctx,
+		"kv-update",
+		func() error {
+			r, err := withContext(ctx, func() (uint64, error) {
+				return kvb.Update(key, newVal, last)
+			})
+			if err == nil {
+				result = r
+			}
+			return err
+		},
+	)
+}
+
+func RobustWatchKV(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, keys string, opts ...nats.WatchOpt) (nats.KeyWatcher, error) {
+	var result nats.KeyWatcher
+	return result, policy.retry(
+		ctx,
+		"kv-watch",
+		func() error {
+			r, err := kvb.Watch(keys, append(opts, nats.Context(ctx))...)
+			if err == nil {
+				result = r
+			}
+			return err
+		},
+	)
+}
+
+func RobustKVKeys(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue) ([]string, error) {
+	var result []string
+	return result, policy.retry(
+		ctx,
+		"kv-keys",
+		func() error {
+			r, err := kvb.Keys(nats.Context(ctx))
+			if err == nil {
+				result = r
+			}
+			return err
+		},
+	)
+}
+
+func RobustKVHistory(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string) ([]nats.KeyValueEntry, error) {
+	var result []nats.KeyValueEntry
+	return result, policy.retry(
+		ctx,
+		"kv-history",
 		func() error {
-			r, err := kvb.Update(key, newVal, last)
+			r, err := kvb.History(key, nats.Context(ctx))
 			if err == nil {
 				result = r
 			}
 			return err
 		},
-		[]error{nats.ErrTimeout},
+	)
+}
+
+func RobustDeleteKVEntry(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string, opts ...nats.DeleteOpt) error {
+	return policy.retry(
+		ctx,
+		"kv-delete",
+		func() error {
+			_, err := withContext(ctx, func() (struct{}, error) {
+				return struct{}{}, kvb.Delete(key, opts...)
+			})
+			return err
+		},
+	)
+}
+
+func RobustPurgeKVEntry(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue, key string, opts ...nats.DeleteOpt) error {
+	return policy.retry(
+		ctx,
+		"kv-purge",
+		func() error {
+			_, err := withContext(ctx, func() (struct{}, error) {
+				return struct{}{}, kvb.Purge(key, opts...)
+			})
+			return err
+		},
+	)
+}
+
+func RobustPurgeKVDeletes(ctx context.Context, policy *RetryPolicy, kvb nats.KeyValue) error {
+	return policy.retry(
+		ctx,
+		"kv-purge-deletes",
+		func() error {
+			return kvb.PurgeDeletes(nats.Context(ctx))
+		},
 	)
 }
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"exec/common"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const infoSuffix = ".json"
+
+// fileInfo is stored next to the content of every object
+type fileInfo struct {
+	Name    string      `json:"name"`
+	Headers http.Header `json:"headers"`
+	Size    uint64      `json:"size"`
+	Digest  string      `json:"digest"`
+	ModTime time.Time   `json:"mod-time"`
+}
+
+// FileSystemStore keeps objects in a directory, e.g. a volume shared by the api and workers.
+// Content is written before the info, so objects appear only once complete
+type FileSystemStore struct {
+	root string
+}
+
+func NewFileSystemStore(root string) (*FileSystemStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSystemStore{root: root}, nil
+}
+
+// path of the content of the object, names are encoded so they can't escape root or collide with infos
+func (s *FileSystemStore) path(name string) string {
+	return filepath.Join(s.root, base64.RawURLEncoding.EncodeToString([]byte(name)))
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return common.ErrObjectNotFound
+	}
+	return err
+}
+
+// writeAtomically renames a temporary file to path once write succeeds
+func (s *FileSystemStore) writeAtomically(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(s.root, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err = write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *FileSystemStore) Put(meta *common.ObjectMeta, data io.Reader, _ context.Context) (*common.ObjectInfo, error) {
+	if meta.Name == "" {
+		return nil, errors.New("object name is empty")
+	}
+	path := s.path(meta.Name)
+	hash := sha256.New()
+	var size int64
+	err := s.writeAtomically(path, func(w io.Writer) error {
+		n, err := io.Copy(io.MultiWriter(w, hash), data)
+		size = n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := &fileInfo{
+		Name:    meta.Name,
+		Headers: meta.Headers,
+		Size:    uint64(size),
+		Digest:  common.ObjectDigest(hash.Sum(nil)),
+		ModTime: time.Now().UTC(),
+	}
+	err = s.writeAtomically(path+infoSuffix, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(info)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.objectInfo(), nil
+}
+
+func (info *fileInfo) objectInfo() *common.ObjectInfo {
+	return &common.ObjectInfo{
+		ObjectMeta: common.ObjectMeta{Name: info.Name, Headers: info.Headers},
+		Size:       info.Size,
+		Digest:     info.Digest,
+		ModTime:    info.ModTime,
+	}
+}
+
+func readInfo(path string) (*common.ObjectInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var info fileInfo
+	if err = json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return info.objectInfo(), nil
+}
+
+func (s *FileSystemStore) Get(name string, _ context.Context) ([]byte, error) {
+	data, err := os.ReadFile(s.path(name))
+	return data, notFound(err)
+}
+
+func (s *FileSystemStore) GetInfo(name string, _ context.Context) (*common.ObjectInfo, error) {
+	return readInfo(s.path(name) + infoSuffix)
+}
+
+// Delete removes the info first, so the object disappears even if removing the content fails
+func (s *FileSystemStore) Delete(name string, _ context.Context) error {
+	path := s.path(name)
+	if err := os.Remove(path + infoSuffix); err != nil {
+		return notFound(err)
+	}
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileSystemStore) Stream(name string, offset int64, _ context.Context) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *FileSystemStore) List(_ context.Context) ([]*common.ObjectInfo, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	var infos []*common.ObjectInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), infoSuffix) || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := readInfo(filepath.Join(s.root, entry.Name()))
+		// Deleted since reading the directory
+		if errors.Is(err, common.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
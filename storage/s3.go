@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"exec/common"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metaHeaderPrefix = "X-Amz-Meta-"
+
+	// digestHeader keeps the digest of nats format, the ETag of S3 isn't a plain digest for multipart uploads
+	digestHeader = metaHeaderPrefix + "Object-Digest"
+)
+
+type S3Config struct {
+	Endpoint        string // Like https://minio.local:9000, buckets are addressed by path
+	Region          string
+	Bucket          string
+	AccessKeyId     string
+	SecretAccessKey string
+	Client          *http.Client // http.DefaultClient if nil
+}
+
+// S3Store keeps objects in a bucket of an S3 compatible service. Headers of objects are stored as user metadata,
+// which keeps only the first value of every header. Values that aren't plain ASCII are RFC 2047 encoded, like AWS SDKs do
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// S3Error is the error response of the service
+type S3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func NewS3Store(config S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3 endpoint %q is not an absolute url", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is empty")
+	}
+	client := config.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Store{config: config, endpoint: endpoint, client: client}, nil
+}
+
+func (s *S3Store) request(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	header http.Header,
+	body io.Reader,
+	size int64,
+	payloadHash string,
+) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3Escape(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signV4(req, s.config.AccessKeyId, s.config.SecretAccessKey, s.config.Region, payloadHash, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	// Responses to HEAD have no body
+	_ = xml.NewDecoder(resp.Body).Decode(s3Err)
+	if resp.StatusCode == http.StatusNotFound && (s3Err.Code == "" || s3Err.Code == "NoSuchKey") {
+		return nil, common.ErrObjectNotFound
+	}
+	return nil, s3Err
+}
+
+// spool stores data in a temporary file unless it's seekable already, so its digest is known before uploading
+func spool(data io.Reader) (io.ReadSeeker, func(), error) {
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		return seeker, func() {}, nil
+	}
+	file, err := os.CreateTemp("", "exec-s3-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	if _, err = io.Copy(file, data); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return file, cleanup, nil
+}
+
+func (s *S3Store) Put(meta *common.ObjectMeta, data io.Reader, ctx context.Context) (*common.ObjectInfo, error) {
+	if meta.Name == "" {
+		return nil, errors.New("object name is empty")
+	}
+	body, cleanup, err := spool(data)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = body.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sum := hash.Sum(nil)
+	info := &common.ObjectInfo{
+		ObjectMeta: common.ObjectMeta{Name: meta.Name, Headers: http.Header{}},
+		Size:       uint64(size),
+		Digest:     common.ObjectDigest(sum),
+	}
+	header := http.Header{}
+	for name, values := range meta.Headers {
+		if len(values) != 0 {
+			info.Headers.Set(name, values[0])
+			header.Set(metaHeaderPrefix+name, mime.QEncoding.Encode("utf-8", values[0]))
+		}
+	}
+	header.Set(digestHeader, info.Digest)
+
+	// Wrapped so the client doesn't close the file
+	resp, err := s.request(ctx, http.MethodPut, meta.Name, nil, header, io.NopCloser(body), size, hex.EncodeToString(sum))
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	info.ModTime = time.Now().UTC()
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+func (s *S3Store) Get(name string, ctx context.Context) ([]byte, error) {
+	reader, err := s.Stream(name, 0, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// decodeMetaValue undoes the RFC 2047 encoding of user metadata, which has to be ASCII to be signed.
+// Values that aren't encoded words are kept as they are
+func decodeMetaValue(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func objectInfoFromHeader(name string, header http.Header) *common.ObjectInfo {
+	info := &common.ObjectInfo{
+		ObjectMeta: common.ObjectMeta{Name: name, Headers: http.Header{}},
+		Digest:     header.Get(digestHeader),
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, metaHeaderPrefix) && key != digestHeader {
+			info.Headers.Set(strings.TrimPrefix(key, metaHeaderPrefix), decodeMetaValue(values[0]))
+		}
+	}
+	info.Size, _ = strconv.ParseUint(header.Get("Content-Length"), 10, 64)
+	info.ModTime, _ = http.ParseTime(header.Get("Last-Modified"))
+	return info
+}
+
+func (s *S3Store) GetInfo(name string, ctx context.Context) (*common.ObjectInfo, error) {
+	resp, err := s.request(ctx, http.MethodHead, name, nil, nil, nil, 0, emptyPayload)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	return objectInfoFromHeader(name, resp.Header), nil
+}
+
+// Delete checks the object exists first, as deleting missing objects from S3 succeeds
+func (s *S3Store) Delete(name string, ctx context.Context) error {
+	if _, err := s.GetInfo(name, ctx); err != nil {
+		return err
+	}
+	resp, err := s.request(ctx, http.MethodDelete, name, nil, nil, nil, 0, emptyPayload)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3Store) Stream(name string, offset int64, ctx context.Context) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.request(ctx, http.MethodGet, name, nil, header, nil, 0, emptyPayload)
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List fetches the info of every object separately, as listings lack user metadata
+func (s *S3Store) List(ctx context.Context) ([]*common.ObjectInfo, error) {
+	var infos []*common.ObjectInfo
+	query := url.Values{"list-type": {"2"}}
+	for {
+		resp, err := s.request(ctx, http.MethodGet, "", query, nil, nil, 0, emptyPayload)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			info, err := s.GetInfo(object.Key, ctx)
+			// Deleted since listing
+			if errors.Is(err, common.ErrObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		if !result.IsTruncated {
+			return infos, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
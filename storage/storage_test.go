@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"exec/common"
+	"exec/memory"
+	nats2 "exec/nats"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, reader io.ReadCloser) string {
+	t.Helper()
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// testObjectStore checks the semantics every backend has to share
+func testObjectStore(t *testing.T, store common.ObjectStore) {
+	ctx := context.Background()
+	const name = "dir/a b+c"
+	content := strings.Repeat("0123456789", 1000)
+
+	if _, err := store.GetInfo(name, ctx); !errors.Is(err, common.ErrObjectNotFound) {
+		t.Fatalf("info of missing object: %v", err)
+	}
+	if infos, err := store.List(ctx); err != nil || len(infos) != 0 {
+		t.Fatalf("list of empty store: %v %v", infos, err)
+	}
+
+	meta := &common.ObjectMeta{Name: name, Headers: http.Header{}}
+	meta.Headers.Set("Exec-Owner", "alice")
+	meta.Headers.Set("Content-Type", "text/plain")
+	meta.Headers.Set("Exec-Filename", "résumé ✓.txt")
+	// Not seekable, like request bodies
+	info, err := store.Put(meta, io.MultiReader(strings.NewReader(content)), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	if info.Name != name || info.Size != uint64(len(content)) || info.Digest != common.ObjectDigest(sum[:]) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	info, err = store.GetInfo(name, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Digest != common.ObjectDigest(sum[:]) || info.Size != uint64(len(content)) || info.ModTime.IsZero() {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.Headers.Get("Exec-Owner") != "alice" || info.Headers.Get("Content-Type") != "text/plain" ||
+		info.Headers.Get("Exec-Filename") != "résumé ✓.txt" {
+		t.Fatalf("unexpected headers %v", info.Headers)
+	}
+
+	data, err := store.Get(name, ctx)
+	if err != nil || string(data) != content {
+		t.Fatalf("get: %v", err)
+	}
+	reader, err := store.Stream(name, 9995, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tail := readAll(t, reader); tail != "56789" {
+		t.Fatalf("streamed %q from offset", tail)
+	}
+	reader, err = store.Stream(name, int64(len(content)), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tail := readAll(t, reader); tail != "" {
+		t.Fatalf("streamed %q from the end", tail)
+	}
+
+	seeker := common.NewObjectReadSeeker(store, info, ctx)
+	part := make([]byte, 3)
+	for _, offset := range []int64{5000, 10, 9997} {
+		if _, err = seeker.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.ReadFull(seeker, part); err != nil {
+			t.Fatal(err)
+		}
+		if string(part) != content[offset:offset+3] {
+			t.Fatalf("read %q at %d", part, offset)
+		}
+	}
+	if err = seeker.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaced by the next put of the same name
+	if _, err = store.Put(&common.ObjectMeta{Name: name}, bytes.NewReader([]byte("new")), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Put(&common.ObjectMeta{Name: "other"}, bytes.NewReader(nil), ctx); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = store.Get(name, ctx); err != nil || string(data) != "new" {
+		t.Fatalf("get after replacing: %q %v", data, err)
+	}
+	infos, err := store.List(ctx)
+	if err != nil || len(infos) != 2 {
+		t.Fatalf("list: %v %v", infos, err)
+	}
+
+	if err = store.Delete(name, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("missing", ctx); !errors.Is(err, common.ErrObjectNotFound) {
+		t.Fatalf("delete of missing object: %v", err)
+	}
+	if _, err = store.Get(name, ctx); !errors.Is(err, common.ErrObjectNotFound) {
+		t.Fatalf("get of deleted object: %v", err)
+	}
+	if _, err = store.Stream(name, 0, ctx); !errors.Is(err, common.ErrObjectNotFound) {
+		t.Fatalf("stream of deleted object: %v", err)
+	}
+	if infos, err = store.List(ctx); err != nil || len(infos) != 1 || infos[0].Name != "other" {
+		t.Fatalf("list after delete: %v %v", infos, err)
+	}
+}
+
+func TestFileSystemStore(t *testing.T) {
+	store, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testObjectStore(t, store)
+}
+
+func TestS3Store(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t, "bucket", "access", "secret"))
+	defer server.Close()
+	store, err := NewS3Store(S3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		AccessKeyId:     "access",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testObjectStore(t, store)
+}
+
+func TestS3StoreRejectsWrongSecret(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t, "bucket", "access", "secret"))
+	defer server.Close()
+	store, err := NewS3Store(S3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		AccessKeyId:     "access",
+		SecretAccessKey: "wrong",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Put(&common.ObjectMeta{Name: "a"}, strings.NewReader("a"), context.Background())
+	var s3Err *S3Error
+	if !errors.As(err, &s3Err) || s3Err.Code != "SignatureDoesNotMatch" {
+		t.Fatalf("put with wrong secret: %v", err)
+	}
+}
+
+func TestNatsStore(t *testing.T) {
+	testObjectStore(t, nats2.NewObjectStoreWrapper(memory.NewObjectStore("test"), nil))
+}